@@ -0,0 +1,49 @@
+package QueryHelper
+
+import (
+	"context"
+)
+
+type actorCtxName string
+
+const actorContext = actorCtxName("actor")
+
+// WithActor stores the identity making changes, it is written to created_by and updated_by columns
+func WithActor(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, actorContext, id)
+}
+
+func GetActor(ctx context.Context) (string, bool) {
+	value, ok := ctx.Value(actorContext).(string)
+	if !ok || value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+// setAuditColumns fills the created_by (on insert) and updated_by columns of the row with the actor from the context
+func (t *Table[T]) setAuditColumns(ctx context.Context, insert bool, row *T) error {
+	actor, found := GetActor(ctx)
+	if !found {
+		return nil
+	}
+	values := map[string]string{}
+	for _, c := range t.Columns {
+		if c.UpdatedBy || (insert && c.CreatedBy) {
+			values[c.Name] = actor
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return setColumnValues(row, values)
+}
+
+func (t *Table[T]) setAuditRows(ctx context.Context, insert bool, rows []T) error {
+	for i := range rows {
+		if err := t.setAuditColumns(ctx, insert, &rows[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package QueryHelper
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type AuditedDocument struct {
+	ID        string `json:"id" db:"id" qc:"primary"`
+	Title     string `json:"title" db:"title" qc:"update"`
+	CreatedBy string `json:"created_by" db:"created_by" qc:"created_by"`
+	UpdatedBy string `json:"updated_by" db:"updated_by" qc:"updated_by"`
+}
+
+func TestAuditColumns(t *testing.T) {
+	documentTable, err := NewTable[AuditedDocument]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(documentTable.UpdateStatement(), "updated_by = COALESCE(NULLIF(:updated_by, ''), updated_by)") {
+		t.Fatalf("expected updated_by to keep the stored value without an actor, got %s", documentTable.UpdateStatement())
+	}
+	if !strings.Contains(documentTable.UpsertStatement(1), "updated_by = COALESCE(NULLIF(VALUES(updated_by), ''), updated_by)") {
+		t.Fatalf("expected upsert to keep the stored updated_by without an actor, got %s", documentTable.UpsertStatement(1))
+	}
+	if strings.Contains(documentTable.UpdateStatement(), "created_by") {
+		t.Fatalf("expected created_by to not be updated, got %s", documentTable.UpdateStatement())
+	}
+
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{rowsAffected: 1}}
+	ctx := WithActor(context.Background(), "user-1")
+	if _, err := documentTable.Insert(ctx, db, AuditedDocument{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	args := db.args[0].(map[string]interface{})
+	if args["0_created_by"] != "user-1" || args["0_updated_by"] != "user-1" {
+		t.Fatalf("expected actor in insert args, got %v", args)
+	}
+
+	ctx = WithActor(context.Background(), "user-2")
+	if err := documentTable.Update(ctx, db, AuditedDocument{ID: "1", CreatedBy: "user-1"}); err != nil {
+		t.Fatal(err)
+	}
+	row := db.args[1].(AuditedDocument)
+	if row.UpdatedBy != "user-2" || row.CreatedBy != "user-1" {
+		t.Fatalf("expected only updated_by to change, got %+v", row)
+	}
+}
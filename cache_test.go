@@ -2,8 +2,12 @@ package QueryHelper
 
 import (
 	"context"
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/Seann-Moser/ctx_cache"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type AccountUserRole struct {
@@ -51,3 +55,256 @@ func TestCacheTest(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+func TestQuery_CacheTags(t *testing.T) {
+	roleTable, err := NewTable[AccountUserRole]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resourceTable, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documentTable, err := NewTable[VersionedDocument]("other", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := QueryTable[AccountUserRole](roleTable)
+	q.Join(resourceTable.Columns, "left").
+		Where(q.Column("user_id"), "=", "AND", 0, "1").
+		Where(documentTable.GetColumn("title"), "=", "AND", 0, "title")
+	tags := q.cacheTags()
+	if len(tags) != 2 || tags[0] != "other.versioned_document" || tags[1] != "test.resource" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	from := QueryTable[AccountUserRole](roleTable).From(QueryTable[AccountUserRole](roleTable).Join(resourceTable.Columns, "left"))
+	if tags := from.cacheTags(); len(tags) != 1 || tags[0] != "test.resource" {
+		t.Fatalf("unexpected from query tags %v", tags)
+	}
+}
+
+func TestQuery_TagCacheKeyInvalidation(t *testing.T) {
+	ctx := context.Background()
+	go ctx_cache.GlobalCacheMonitor.Start(ctx)
+	roleTable, err := NewTable[AccountUserRole]("tags", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resourceTable, err := NewTable[Resource]("tags", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := QueryTable[AccountUserRole](roleTable).Join(resourceTable.Columns, "left")
+
+	group := roleTable.FullTableName()
+	if err := ctx_cache.SetWithExpiration[[]*AccountUserRole](ctx, time.Minute, group, "joined", []*AccountUserRole{{UserID: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	tagCacheKey(ctx, q.cacheTags(), ctx_cache.GetKey[[]*AccountUserRole](group, "joined"))
+
+	for i := 0; i < 100; i++ {
+		_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, resourceTable.FullTableName())
+		if v, _ := ctx_cache.Get[[]*AccountUserRole](ctx, group, "joined"); v == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected joined query to be invalidated by a write to the joined table")
+}
+
+func TestCachedFetch_Coalesces(t *testing.T) {
+	ctx := context.Background()
+	var calls int32
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) ([]string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []string{"row"}, nil
+	}
+	options := cacheOptions{duration: time.Minute}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rows, err := cachedFetch[[]string](ctx, options, "coalesce", "key", func(v []string) bool { return len(v) == 0 }, fetch)
+			if err != nil || len(rows) != 1 {
+				t.Errorf("unexpected result %v: %v", rows, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("expected a single fetch, got %d", calls)
+	}
+}
+
+func TestCachedFetch_CoalescedCallersGetCopies(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) ([]*AccountUserRole, error) {
+		<-release
+		return []*AccountUserRole{{RoleID: "admin"}}, nil
+	}
+	options := cacheOptions{duration: time.Minute}
+	results := make([][]*AccountUserRole, 2)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = cachedFetch[[]*AccountUserRole](ctx, options, "coalesce_copies", "key", func(v []*AccountUserRole) bool { return len(v) == 0 }, fetch)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if len(results[0]) != 1 || len(results[1]) != 1 {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if results[0][0] == results[1][0] {
+		t.Fatal("expected coalesced callers not to share rows")
+	}
+	results[0][0].RoleID = "changed"
+	if results[1][0].RoleID != "admin" {
+		t.Fatalf("expected rows of other callers to be unchanged, got %s", results[1][0].RoleID)
+	}
+}
+
+func TestCachedFetch_StaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	var calls int32
+	fetch := func(ctx context.Context) (int, error) {
+		return int(atomic.AddInt32(&calls, 1)), nil
+	}
+	options := cacheOptions{duration: 50 * time.Millisecond, stale: time.Minute}
+	isEmpty := func(int) bool { return false }
+	if v, _ := cachedFetch[int](ctx, options, "stale", "key", isEmpty, fetch); v != 1 {
+		t.Fatalf("expected first value, got %d", v)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if v, _ := cachedFetch[int](ctx, options, "stale", "key", isEmpty, fetch); v != 1 {
+		t.Fatalf("expected stale value while revalidating, got %d", v)
+	}
+	for i := 0; i < 100; i++ {
+		if v, _ := cachedFetch[int](ctx, options, "stale", "key", isEmpty, fetch); v > 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected value to be refreshed in the background")
+}
+
+func TestCachedFetch_NegativeCache(t *testing.T) {
+	ctx := context.Background()
+	var calls int32
+	fetch := func(ctx context.Context) ([]string, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, nil
+	}
+	isEmpty := func(v []string) bool { return len(v) == 0 }
+	for i := 0; i < 2; i++ {
+		_, _ = cachedFetch[[]string](ctx, cacheOptions{duration: time.Minute}, "negative", "uncached", isEmpty, fetch)
+	}
+	if calls != 2 {
+		t.Fatalf("expected empty results not to be cached, got %d fetches", calls)
+	}
+	calls = 0
+	options := cacheOptions{duration: time.Minute, negative: time.Minute}
+	for i := 0; i < 2; i++ {
+		_, _ = cachedFetch[[]string](ctx, options, "negative", "cached", isEmpty, fetch)
+	}
+	if calls != 1 {
+		t.Fatalf("expected empty result to be cached, got %d fetches", calls)
+	}
+}
+
+func cacheKeyQuery(t *testing.T) *Query[AccountUserRole] {
+	table, err := NewTable[AccountUserRole]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := QueryTable[AccountUserRole](table)
+	q.Select(q.Column("user_id"), q.Column("role_id")).
+		Where(q.Column("account_id"), "=", "AND", 0, "a1").
+		Where(q.Column("role_id"), "in", "AND", 0, []string{"r1", "r2"}).
+		OrderBy(q.Column("user_id")).
+		Limit(10)
+	return q
+}
+
+func TestQuery_CacheKeyGolden(t *testing.T) {
+	q := cacheKeyQuery(t)
+	expected := `from=22:test.account_user_role;lock=5:false5:false;` +
+		`select=25:account_user_role.user_id;select=25:account_user_role.role_id;` +
+		`where=42:account_user_role.account_id = :account_id1:03:AND;` +
+		`where=39:account_user_role.role_id in (:role_id)1:03:AND;` +
+		`order_by=12:user_id DESC;limit=2:101:01:0;` +
+		`arg=10:account_id4:"a1";arg=7:role_id11:["r1","r2"];`
+	if key := q.cacheKeyString(); key != expected {
+		t.Fatalf("unexpected cache key encoding\nexpected: %s\nactual:   %s", expected, key)
+	}
+	if key := q.GetCacheKey(); key != "c70c741bf094029c164762239853f3b0" {
+		t.Fatalf("unexpected cache key %s", key)
+	}
+}
+
+func TestQuery_CacheKeyDeterministic(t *testing.T) {
+	q := cacheKeyQuery(t)
+	key := q.GetCacheKey()
+	for i := 0; i < 50; i++ {
+		if next := cacheKeyQuery(t).GetCacheKey(); next != key {
+			t.Fatalf("cache key changed between identical queries: %s != %s", next, key)
+		}
+	}
+}
+
+func TestQuery_CacheKeyOrderBy(t *testing.T) {
+	byUser := cacheKeyQuery(t)
+	byRole := cacheKeyQuery(t)
+	byRole.OrderByStmt = nil
+	byRole.OrderBy(byRole.Column("role_id"))
+	if byUser.GetCacheKey() == byRole.GetCacheKey() {
+		t.Fatal("queries with different orderings share a cache key")
+	}
+	changedArg := cacheKeyQuery(t)
+	if byUser.GetCacheKey() == changedArg.GetCacheKey(map[string]interface{}{"account_id": "a2"}) {
+		t.Fatal("queries with different arguments share a cache key")
+	}
+}
+
+func TestSafeString_Float(t *testing.T) {
+	if v := safeString(1.5); v != "1.5" {
+		t.Fatalf("expected 1.5, got %s", v)
+	}
+	if v := safeString(float32(2)); v != "2" {
+		t.Fatalf("expected 2, got %s", v)
+	}
+}
+
+func TestQuery_SkipCache(t *testing.T) {
+	ctx := context.Background()
+	roleTable, err := NewTable[AccountUserRole]("skip", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, mock := newSqlMock(t)
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`FROM skip\.account_user_role`).
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "user_id", "role_id"}).AddRow("a", "u", "r"))
+	}
+	q := QueryTable[AccountUserRole](roleTable)
+	q = q.Where(q.Column("user_id"), "=", "AND", 0, "u").UseCache().SkipCache()
+	for i := 0; i < 2; i++ {
+		if _, err := q.Run(ctx, NewSql(sqlDB)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expected every run to read from the db: %v", err)
+	}
+}
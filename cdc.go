@@ -0,0 +1,271 @@
+package QueryHelper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Seann-Moser/go-serve/pkg/ctxLogger"
+	"go.uber.org/zap"
+)
+
+const (
+	ChangeCheckpointTableName = "change_checkpoint"
+
+	watermarkFormat            = "2006-01-02 15:04:05.000000"
+	defaultChangeBatchSize     = 500
+	defaultChangePollInterval  = 5 * time.Second
+	defaultChangeSkewTolerance = 5 * time.Second
+)
+
+var ErrMissingUpdatedTimestamp = errors.New("table has no column with default::updated_timestamp")
+
+// ChangeCheckpoint is the durable position of a ChangeReader, the last row read is identified by its
+// updated timestamp and primary key values
+type ChangeCheckpoint struct {
+	Consumer         string `json:"consumer" db:"consumer" qc:"primary;data_type::varchar(256)"`
+	TableName        string `json:"table_name" db:"table_name" qc:"primary;data_type::varchar(256)"`
+	Watermark        string `json:"watermark" db:"watermark" qc:"update;data_type::varchar(32)"`
+	LastKey          string `json:"last_key" db:"last_key" qc:"update;data_type::json"`
+	UpdatedTimestamp string `json:"updated_timestamp" db:"updated_timestamp" qc:"skip;default::updated_timestamp"`
+}
+
+// ChangeHandler receives a batch of changed rows in updated timestamp order, the checkpoint only moves
+// forward when it returns nil so a failed batch is read again
+type ChangeHandler[T any] func(ctx context.Context, events []ChangeEvent[T]) error
+
+// ChangeReader pages through the rows of a table that changed since its last checkpoint, using the
+// updated_timestamp column as a watermark and the primary key to break ties. Polling can not tell inserts
+// from updates, so every event has OperationUpsert, and deleted rows are not seen.
+type ChangeReader[T any] struct {
+	table       *Table[T]
+	db          DB
+	consumer    string
+	timestamp   Column
+	checkpoints *Table[ChangeCheckpoint]
+
+	BatchSize    int
+	PollInterval time.Duration
+	// SkewTolerance only reads rows older than the database clock minus this duration. Rows written by
+	// transactions that commit late, or by writers with a slow clock, can land behind the watermark, the
+	// tolerance should be larger than the longest write transaction.
+	SkewTolerance time.Duration
+}
+
+// NewChangeReader creates a reader for the table, consumer names the checkpoint so several readers can follow the same table
+func NewChangeReader[T any](ctx context.Context, table *Table[T], db DB, consumer string) (*ChangeReader[T], error) {
+	if db == nil {
+		db = table.db
+	}
+	if db == nil {
+		return nil, fmt.Errorf("no db set")
+	}
+	var timestamp Column
+	for _, c := range table.Columns {
+		if c.Default == "updated_timestamp" {
+			timestamp = c
+			break
+		}
+	}
+	if timestamp.Name == "" {
+		return nil, ErrMissingUpdatedTimestamp
+	}
+	checkpoints, err := newInternalTable[ChangeCheckpoint](ctx, db, table.Dataset, ChangeCheckpointTableName, table.QueryType)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating checkpoint table: %w", err)
+	}
+	return &ChangeReader[T]{
+		table:         table,
+		db:            db,
+		consumer:      consumer,
+		timestamp:     timestamp,
+		checkpoints:   checkpoints,
+		BatchSize:     defaultChangeBatchSize,
+		PollInterval:  defaultChangePollInterval,
+		SkewTolerance: defaultChangeSkewTolerance,
+	}, nil
+}
+
+// Run reads changes until the context is cancelled
+func (r *ChangeReader[T]) Run(ctx context.Context, handler ChangeHandler[T]) error {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			read, err := r.ReadOnce(ctx, handler)
+			if err != nil {
+				ctxLogger.Warn(ctx, "failed reading changes", zap.String("table", r.table.FullTableName()), zap.Error(err))
+			}
+			if err != nil || read < r.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReadOnce passes the next batch of changes to handler and stores the new checkpoint, it returns the number of rows read
+func (r *ChangeReader[T]) ReadOnce(ctx context.Context, handler ChangeHandler[T]) (int, error) {
+	checkpoint, err := r.Checkpoint(ctx)
+	if err != nil {
+		return 0, err
+	}
+	query, args, err := r.changesQuery(checkpoint)
+	if err != nil {
+		return 0, err
+	}
+	// integer keys above 2^53 must reach the db as int64
+	rows, err := r.table.selectArgs(ctx, r.db, "", query, args)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	events := make([]ChangeEvent[T], 0, len(rows))
+	for _, row := range rows {
+		events = append(events, r.table.changeEvent(OperationUpsert, *row))
+	}
+	if err := handler(ctx, events); err != nil {
+		return 0, err
+	}
+
+	last := events[len(events)-1]
+	watermark, err := watermarkString(columnValues(last.Row)[r.timestamp.Name])
+	if err != nil {
+		return 0, err
+	}
+	lastKey, err := json.Marshal(last.Keys)
+	if err != nil {
+		return 0, err
+	}
+	checkpoint.Watermark = watermark
+	checkpoint.LastKey = string(lastKey)
+	if _, err := r.checkpoints.Upsert(ctx, r.db, *checkpoint); err != nil {
+		return 0, fmt.Errorf("failed storing checkpoint: %w", err)
+	}
+	return len(rows), nil
+}
+
+// Checkpoint returns the stored position of the reader, a new reader starts from the beginning of the table
+func (r *ChangeReader[T]) Checkpoint(ctx context.Context) (*ChangeCheckpoint, error) {
+	q := QueryTable[ChangeCheckpoint](r.checkpoints)
+	checkpoint, err := q.Where(q.Column("consumer"), "=", "AND", 0, r.consumer).
+		Where(q.Column("table_name"), "=", "AND", 0, r.table.Name).
+		RunSingle(ctx, r.db)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && checkpoint == nil) {
+		return &ChangeCheckpoint{Consumer: r.consumer, TableName: r.table.Name}, nil
+	}
+	return checkpoint, err
+}
+
+// Reset removes the checkpoint so the next read starts from the beginning of the table
+func (r *ChangeReader[T]) Reset(ctx context.Context) error {
+	return r.checkpoints.Delete(ctx, r.db, ChangeCheckpoint{Consumer: r.consumer, TableName: r.table.Name})
+}
+
+func (r *ChangeReader[T]) changesQuery(checkpoint *ChangeCheckpoint) (string, map[string]interface{}, error) {
+	primary := r.table.GetPrimary()
+	sort.Slice(primary, func(i, j int) bool {
+		return primary[i].ColumnOrder < primary[j].ColumnOrder
+	})
+	orderBy := []string{r.timestamp.Name}
+	for _, c := range primary {
+		orderBy = append(orderBy, c.Name)
+	}
+
+	args := map[string]interface{}{}
+	where := []string{fmt.Sprintf("%s < NOW(6) - INTERVAL %d MICROSECOND", r.timestamp.Name, r.SkewTolerance.Microseconds())}
+	if checkpoint.Watermark != "" {
+		keys, err := checkpointKeys(checkpoint.LastKey)
+		if err != nil {
+			return "", nil, err
+		}
+		var keyColumns, keyParams []string
+		for _, c := range primary {
+			keyColumns = append(keyColumns, c.Name)
+			keyParams = append(keyParams, ":cdc_key_"+c.Name)
+			args["cdc_key_"+c.Name] = keys[c.Name]
+		}
+		args["cdc_watermark"] = checkpoint.Watermark
+		keyCondition := fmt.Sprintf("(%s) > (%s)", strings.Join(keyColumns, ","), strings.Join(keyParams, ","))
+		if len(keyColumns) == 1 {
+			// a single parameter in parentheses is expanded as an in list by fixArrays
+			keyCondition = fmt.Sprintf("%s > %s", keyColumns[0], keyParams[0])
+		}
+		where = append(where, fmt.Sprintf("(%[1]s > :cdc_watermark OR (%[1]s = :cdc_watermark AND %[2]s))",
+			r.timestamp.Name, keyCondition))
+	}
+
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT %d",
+		strings.Join(r.table.GetSelectableColumns(false), ","),
+		r.table.FullTableName(),
+		strings.Join(where, " AND "),
+		strings.Join(orderBy, ","),
+		r.BatchSize,
+	), args, nil
+}
+
+// checkpointKeys decodes the primary key values of the last row that was read. Integer keys are kept as int64,
+// decoding them as float64 would lose precision above 2^53.
+func checkpointKeys(lastKey string) (map[string]interface{}, error) {
+	keys := map[string]interface{}{}
+	if lastKey == "" {
+		return keys, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(lastKey))
+	decoder.UseNumber()
+	if err := decoder.Decode(&keys); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint key: %w", err)
+	}
+	for k, v := range keys {
+		number, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+		if i, err := number.Int64(); err == nil {
+			keys[k] = i
+		} else if f, err := number.Float64(); err == nil {
+			keys[k] = f
+		}
+	}
+	return keys, nil
+}
+
+func watermarkString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(watermarkFormat), nil
+	case *time.Time:
+		if v == nil {
+			return "", fmt.Errorf("updated timestamp is null")
+		}
+		return v.Format(watermarkFormat), nil
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t.Format(watermarkFormat), nil
+		}
+		return v, nil
+	case *string:
+		if v == nil {
+			return "", fmt.Errorf("updated timestamp is null")
+		}
+		return watermarkString(*v)
+	case []byte:
+		return string(v), nil
+	}
+	return "", fmt.Errorf("unsupported updated timestamp type %T", value)
+}
@@ -0,0 +1,171 @@
+package QueryHelper
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type SyncedDocument struct {
+	ID               string `json:"id" db:"id" qc:"primary"`
+	Title            string `json:"title" db:"title" qc:"update"`
+	UpdatedTimestamp string `json:"updated_timestamp" db:"updated_timestamp" qc:"skip;default::updated_timestamp"`
+}
+
+func TestChangeReader_ReadOnce(t *testing.T) {
+	ctx := context.Background()
+	db := NewMockDB()
+	documentTable, err := NewTable[SyncedDocument]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := documentTable.InitializeTable(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewChangeReader[SyncedDocument](ctx, documentTable, db, "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := db.tables["test.change_checkpoint"]; !found {
+		t.Fatal("expected checkpoint table to be created")
+	}
+	read, err := reader.ReadOnce(ctx, func(ctx context.Context, events []ChangeEvent[SyncedDocument]) error {
+		t.Fatal("expected no events")
+		return nil
+	})
+	if err != nil || read != 0 {
+		t.Fatalf("expected empty read, got %d: %v", read, err)
+	}
+}
+
+func TestChangeReader_MissingTimestamp(t *testing.T) {
+	documentTable, err := NewTable[VersionedDocument]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewChangeReader[VersionedDocument](context.Background(), documentTable, NewMockDB(), "search"); err != ErrMissingUpdatedTimestamp {
+		t.Fatalf("expected ErrMissingUpdatedTimestamp, got %v", err)
+	}
+}
+
+func TestChangeReader_ChangesQuery(t *testing.T) {
+	documentTable, err := NewTable[SyncedDocument]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewChangeReader[SyncedDocument](context.Background(), documentTable, NewMockDB(), "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.BatchSize = 10
+	reader.SkewTolerance = 2 * time.Second
+
+	query, args, err := reader.changesQuery(&ChangeCheckpoint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, "WHERE updated_timestamp < NOW(6) - INTERVAL 2000000 MICROSECOND ORDER BY updated_timestamp,id LIMIT 10") || len(args) != 0 {
+		t.Fatalf("unexpected initial query %s %v", query, args)
+	}
+
+	query, args, err = reader.changesQuery(&ChangeCheckpoint{Watermark: "2024-01-02 03:04:05.000000", LastKey: `{"id":"7"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, "(updated_timestamp > :cdc_watermark OR (updated_timestamp = :cdc_watermark AND id > :cdc_key_id))") {
+		t.Fatalf("unexpected query %s", query)
+	}
+	if args["cdc_watermark"] != "2024-01-02 03:04:05.000000" || args["cdc_key_id"] != "7" {
+		t.Fatalf("unexpected args %v", args)
+	}
+}
+
+func TestChangeReader_ChangesQueryCompositeKey(t *testing.T) {
+	roleTable, err := NewTable[AccountUserRole]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewChangeReader[AccountUserRole](context.Background(), roleTable, NewMockDB(), "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, _, err := reader.changesQuery(&ChangeCheckpoint{Watermark: "2024-01-02 03:04:05.000000", LastKey: `{"account_id":"a","user_id":"u","role_id":"r"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, "AND (account_id,user_id,role_id) > (:cdc_key_account_id,:cdc_key_user_id,:cdc_key_role_id)))") {
+		t.Fatalf("expected composite keys to be compared as a row, got %s", query)
+	}
+}
+
+func TestWatermarkString(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	for _, value := range []interface{}{ts, &ts, "2024-01-02T03:04:05.000006Z", "2024-01-02 03:04:05.000006"} {
+		watermark, err := watermarkString(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if watermark != "2024-01-02 03:04:05.000006" {
+			t.Fatalf("unexpected watermark %s for %v", watermark, value)
+		}
+	}
+}
+
+type SyncedCounter struct {
+	ID               int64  `json:"id" db:"id" qc:"primary"`
+	Count            int    `json:"count" db:"count" qc:"update"`
+	UpdatedTimestamp string `json:"updated_timestamp" db:"updated_timestamp" qc:"skip;default::updated_timestamp"`
+}
+
+func TestChangeReader_ChangesQueryLargeKey(t *testing.T) {
+	counterTable, err := NewTable[SyncedCounter]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewChangeReader[SyncedCounter](context.Background(), counterTable, NewMockDB(), "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2^53 + 1 can not be represented by a float64
+	_, args, err := reader.changesQuery(&ChangeCheckpoint{Watermark: "2024-01-02 03:04:05.000000", LastKey: `{"id":9007199254740993}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args["cdc_key_id"] != int64(9007199254740993) {
+		t.Fatalf("expected the key to keep its precision, got %v (%T)", args["cdc_key_id"], args["cdc_key_id"])
+	}
+}
+
+func TestChangeReader_ReadOnceLargeKey(t *testing.T) {
+	ctx := context.Background()
+	counterTable, err := NewTable[SyncedCounter]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewChangeReader[SyncedCounter](ctx, counterTable, NewMockDB(), "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, mock := newSqlMock(t)
+	reader.db = NewSql(sqlDB)
+
+	mock.ExpectQuery(`FROM\s+test\.change_checkpoint`).
+		WillReturnRows(sqlmock.NewRows([]string{"consumer", "table_name", "watermark", "last_key"}).
+			AddRow("search", "synced_counter", "2024-01-02 03:04:05.000000", `{"id":9007199254740993}`))
+	// 2^53 + 1 can not be represented by a float64
+	mock.ExpectQuery(`FROM test\.synced_counter WHERE`).
+		WithArgs("2024-01-02 03:04:05.000000", "2024-01-02 03:04:05.000000", int64(9007199254740993)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "count", "updated_timestamp"}))
+
+	if _, err := reader.ReadOnce(ctx, func(ctx context.Context, events []ChangeEvent[SyncedCounter]) error {
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,106 @@
+package QueryHelper
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// ChangeEvent describes a row that was written by Insert, Upsert, Update or Delete.
+// Keys holds the primary key values, Row is the row as it was written.
+type ChangeEvent[T any] struct {
+	Operation Operation
+	Table     string
+	Keys      map[string]interface{}
+	Row       *T
+}
+
+type changeSubscriber struct {
+	id      int64
+	handler func(event interface{})
+}
+
+var (
+	changeSubscribersMutex = &sync.RWMutex{}
+	changeSubscribers      = map[reflect.Type][]changeSubscriber{}
+	changeSubscriberID     int64
+)
+
+// Subscribe calls handler after every successful write to the table of T made in this process. Handlers run
+// synchronously in the writing goroutine before the after hooks, so a write is published even when an after hook
+// fails, and slow work should be handed off. Writes made with the Tx methods are
+// not published since the commit is up to the caller. The subscription ends when ctx is done or the returned
+// function is called.
+func Subscribe[T any](ctx context.Context, handler func(ChangeEvent[T])) func() {
+	key := reflect.TypeOf((*T)(nil)).Elem()
+	changeSubscribersMutex.Lock()
+	changeSubscriberID++
+	id := changeSubscriberID
+	changeSubscribers[key] = append(changeSubscribers[key], changeSubscriber{
+		id: id,
+		handler: func(event interface{}) {
+			handler(event.(ChangeEvent[T]))
+		},
+	})
+	changeSubscribersMutex.Unlock()
+
+	once := sync.Once{}
+	stop := make(chan struct{})
+	unsubscribe := func() {
+		once.Do(func() {
+			close(stop)
+			changeSubscribersMutex.Lock()
+			defer changeSubscribersMutex.Unlock()
+			subscribers := changeSubscribers[key]
+			for i, s := range subscribers {
+				if s.id == id {
+					changeSubscribers[key] = append(subscribers[:i:i], subscribers[i+1:]...)
+					break
+				}
+			}
+			if len(changeSubscribers[key]) == 0 {
+				delete(changeSubscribers, key)
+			}
+		})
+	}
+	if done := ctx.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				unsubscribe()
+			case <-stop:
+			}
+		}()
+	}
+	return unsubscribe
+}
+
+func (t *Table[T]) publishChanges(operation Operation, rows ...T) {
+	key := reflect.TypeOf((*T)(nil)).Elem()
+	changeSubscribersMutex.RLock()
+	subscribers := changeSubscribers[key]
+	changeSubscribersMutex.RUnlock()
+	if len(subscribers) == 0 {
+		return
+	}
+	for i := range rows {
+		event := t.changeEvent(operation, rows[i])
+		for _, s := range subscribers {
+			s.handler(event)
+		}
+	}
+}
+
+func (t *Table[T]) changeEvent(operation Operation, row T) ChangeEvent[T] {
+	values := columnValues(&row)
+	keys := map[string]interface{}{}
+	for _, c := range t.GetPrimary() {
+		keys[c.Name] = values[c.Name]
+	}
+	return ChangeEvent[T]{
+		Operation: operation,
+		Table:     t.FullTableName(),
+		Keys:      keys,
+		Row:       &row,
+	}
+}
@@ -0,0 +1,107 @@
+package QueryHelper
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{rowsAffected: 1}}
+	documentTable, err := NewTable[VersionedDocument]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []ChangeEvent[VersionedDocument]
+	unsubscribe := Subscribe[VersionedDocument](ctx, func(event ChangeEvent[VersionedDocument]) {
+		events = append(events, event)
+	})
+	if err := documentTable.Update(ctx, db, VersionedDocument{ID: "1", Title: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := documentTable.Delete(ctx, db, VersionedDocument{ID: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Operation != OperationUpdate || events[0].Keys["id"] != "1" || events[0].Row.Title != "new" {
+		t.Fatalf("unexpected update event %+v", events[0])
+	}
+	if events[1].Operation != OperationDelete || events[1].Keys["id"] != "2" || events[1].Table != "test.versioned_document" {
+		t.Fatalf("unexpected delete event %+v", events[1])
+	}
+
+	unsubscribe()
+	if err := documentTable.Update(ctx, db, VersionedDocument{ID: "1", Title: "newer"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected no events after unsubscribing, got %d", len(events))
+	}
+}
+
+func TestSubscribe_FailedWrite(t *testing.T) {
+	ctx := context.Background()
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{}}
+	documentTable, err := NewTable[VersionedDocument]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	defer Subscribe[VersionedDocument](ctx, func(event ChangeEvent[VersionedDocument]) {
+		called = true
+	})()
+	if err := documentTable.ErrOnNoRows().Update(ctx, db, VersionedDocument{ID: "1"}); err == nil {
+		t.Fatal("expected error when no rows are updated")
+	}
+	if called {
+		t.Fatal("expected no event for a failed update")
+	}
+}
+
+var errAfterUpdate = errors.New("after update failed")
+
+type AfterHookDocument struct {
+	ID    string `json:"id" db:"id" qc:"primary"`
+	Title string `json:"title" db:"title" qc:"update"`
+}
+
+func (d *AfterHookDocument) AfterUpdate(ctx context.Context) error {
+	return errAfterUpdate
+}
+
+func TestSubscribe_BeforeAfterHook(t *testing.T) {
+	ctx := context.Background()
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{rowsAffected: 1}}
+	documentTable, err := NewTable[AfterHookDocument]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	defer Subscribe[AfterHookDocument](ctx, func(event ChangeEvent[AfterHookDocument]) {
+		called = true
+	})()
+	if err := documentTable.Update(ctx, db, AfterHookDocument{ID: "1"}); !errors.Is(err, errAfterUpdate) {
+		t.Fatalf("expected the after hook error, got %v", err)
+	}
+	if !called {
+		t.Fatal("expected the committed update to be published when the after hook fails")
+	}
+}
+
+func TestSubscribe_UnsubscribeEndsGoroutine(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	before := runtime.NumGoroutine()
+	Subscribe[AfterHookDocument](ctx, func(event ChangeEvent[AfterHookDocument]) {})()
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if runtime.NumGoroutine() > before {
+		t.Fatal("expected unsubscribe to end the subscription goroutine")
+	}
+}
@@ -49,6 +49,15 @@ type Column struct {
 
 	Encrypt bool `json:"encrypt"`
 	Decrypt bool `json:"decrypt"`
+
+	CreatedBy bool `json:"created_by"`
+	UpdatedBy bool `json:"updated_by"`
+
+	// window is set for columns computed by a window function and derived for columns that are not in the table
+	window  *windowFunction
+	derived bool
+	// alias is the join alias the column is referenced through, see Of
+	alias string
 }
 
 func GetAllNumbersAsInt(input string) ([]int, error) {
@@ -126,13 +135,19 @@ func (col *Column) GetDefinition() string {
 	}
 
 	// Add AUTO_INCREMENT if applicable
-	if col.AutoGenerateID && strings.Contains(strings.ToLower(col.Type), "int") {
+	if col.IsAutoIncrement() {
 		definition += " AUTO_INCREMENT"
 	}
 
 	return definition
 }
 
+// IsAutoIncrement reports whether the database generates the value of the column,
+// integer columns with an explicit auto_generate_id_type are generated by the application
+func (col *Column) IsAutoIncrement() bool {
+	return col.AutoGenerateID && col.AutoGenerateIDType == "" && strings.Contains(strings.ToLower(col.Type), "int")
+}
+
 func (col *Column) HasFK() bool {
 	if col.ForeignSchema == "" {
 		col.ForeignSchema = col.Dataset
@@ -170,23 +185,20 @@ func (c Column) SetDataset(d string) Column {
 	return c
 }
 
+// Of references the column through the alias of a join, see Query.JoinOn
+func (c Column) Of(alias string) Column {
+	c.alias = alias
+	return c
+}
+
 func (c Column) As(as string) Column {
 	c.SelectAs = as
 	return c
 }
 
 func (c *Column) FullName(groupBy bool, inSelect bool) string {
-	name := fmt.Sprintf("%s.%s", c.Table, c.Name)
-	if c.Wrapper != "" && inSelect {
-		name = fmt.Sprintf(c.Wrapper, name)
-	}
-
-	if groupBy && len(c.GroupByModifier) > 0 && !c.ignoreGroupBy {
-		if strings.Contains(c.GroupByModifier, "*") {
-			name = strings.ReplaceAll(c.GroupByModifier, "*", name)
-		} else {
-			name = fmt.Sprintf("%s(%s)", c.GroupByModifier, name)
-		}
+	name := c.expression(groupBy, inSelect)
+	if c.aggregated(groupBy) {
 		if c.SelectAs == "" && inSelect {
 			if c.GroupByName != "" {
 				name = fmt.Sprintf("%s AS %s", name, c.GroupByName)
@@ -197,10 +209,50 @@ func (c *Column) FullName(groupBy bool, inSelect bool) string {
 	}
 	if c.SelectAs != "" && inSelect {
 		name = fmt.Sprintf("%s AS %s", name, c.SelectAs)
+	} else if c.window != nil && inSelect {
+		name = fmt.Sprintf("%s AS %s", name, c.Name)
 	}
 	return name
 }
 
+// expression is the column without its alias, wrapped and aggregated the way the select list renders it
+func (c *Column) expression(groupBy bool, wrap bool) string {
+	if c.window != nil {
+		return c.window.render()
+	}
+	table := c.Table
+	if c.alias != "" {
+		table = c.alias
+	}
+	name := fmt.Sprintf("%s.%s", table, c.Name)
+	if c.Wrapper != "" && wrap {
+		name = fmt.Sprintf(c.Wrapper, name)
+	}
+	if c.aggregated(groupBy) {
+		if strings.Contains(c.GroupByModifier, "*") {
+			name = strings.ReplaceAll(c.GroupByModifier, "*", name)
+		} else {
+			name = fmt.Sprintf("%s(%s)", c.GroupByModifier, name)
+		}
+	}
+	return name
+}
+
+func (c *Column) aggregated(groupBy bool) bool {
+	return groupBy && len(c.GroupByModifier) > 0 && !c.ignoreGroupBy
+}
+
+// havingName references the column in a having clause, by its select alias when it has one
+func (c *Column) havingName(groupBy bool) string {
+	if c.SelectAs != "" {
+		return c.SelectAs
+	}
+	if c.aggregated(groupBy) && c.GroupByName != "" {
+		return c.GroupByName
+	}
+	return c.expression(groupBy, true)
+}
+
 func (c *Column) FullTableName() string {
 	return fmt.Sprintf("%s.%s", c.Dataset, c.Table)
 }
@@ -0,0 +1,97 @@
+package QueryHelper
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+type commonTableExpression struct {
+	name      string
+	sub       Subquery
+	recursive bool
+}
+
+// With names the subquery so the query, its subqueries and later expressions can reference it, see FromWith
+func (q *Query[T]) With(name string, sub Subquery) *Query[T] {
+	return q.addWith(name, sub, false)
+}
+
+// WithRecursive names a subquery that references itself, it renders the whole with clause as recursive
+func (q *Query[T]) WithRecursive(name string, sub Subquery) *Query[T] {
+	return q.addWith(name, sub, true)
+}
+
+func (q *Query[T]) addWith(name string, sub Subquery, recursive bool) *Query[T] {
+	q = q.edit()
+	if !identifier.MatchString(name) {
+		if q.err == nil {
+			q.err = fmt.Errorf("%w: invalid common table expression name %q", ErrInvalidCondition, name)
+		}
+		return q
+	}
+	for _, cte := range q.ctes {
+		if strings.EqualFold(cte.name, name) {
+			if q.err == nil {
+				q.err = fmt.Errorf("%w: duplicate common table expression %q", ErrInvalidCondition, name)
+			}
+			return q
+		}
+	}
+	if _, _, err := renamedSubquery(sub, ""); err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return q
+	}
+	q.cansave = false
+	q.ctes = append(q.ctes, commonTableExpression{name: name, sub: sub, recursive: recursive})
+	return q
+}
+
+// FromWith selects from the named common table expression instead of the table. It is aliased as the table so the
+// columns of the table reference it, the expression must return the columns that are selected.
+func (q *Query[T]) FromWith(name string) *Query[T] {
+	q = q.edit()
+	if !identifier.MatchString(name) {
+		if q.err == nil {
+			q.err = fmt.Errorf("%w: invalid common table expression name %q", ErrInvalidCondition, name)
+		}
+		return q
+	}
+	q.fromWith = name
+	return q
+}
+
+// renderWith returns the with clause and the values of its parameters, the parameters of every expression are
+// prefixed with its name so they can not collide
+func (q *Query[T]) renderWith() (string, map[string]interface{}) {
+	args := map[string]interface{}{}
+	if len(q.ctes) == 0 {
+		return "", args
+	}
+	var expressions []string
+	recursive := false
+	for _, cte := range q.ctes {
+		// expressions are checked when they are added
+		query, subArgs, err := renamedSubquery(cte.sub, "with_"+cte.name+"_")
+		if err != nil {
+			continue
+		}
+		recursive = recursive || cte.recursive
+		expressions = append(expressions, fmt.Sprintf("%s AS (\n\t%s\n)", cte.name, strings.ReplaceAll(query, "\n", "\n\t")))
+		for k, v := range subArgs {
+			args[k] = v
+		}
+	}
+	if len(expressions) == 0 {
+		return "", args
+	}
+	keyword := "WITH"
+	if recursive {
+		keyword = "WITH RECURSIVE"
+	}
+	return fmt.Sprintf("%s %s\n", keyword, strings.Join(expressions, ", ")), args
+}
@@ -2,6 +2,7 @@ package QueryHelper
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
@@ -84,11 +85,38 @@ func InsertCtx[T any](ctx context.Context, data *T, suffix ...string) (string, e
 	if data == nil {
 		return "", fmt.Errorf("no data provided")
 	}
-	id, err := table.Insert(ctx, nil, *data)
+	rows := []T{*data}
+	rowIDs, err := table.insertRows(ctx, nil, rows)
 	if err != nil {
 		return "", err
 	}
-	return id, nil
+	*data = rows[0]
+	return table.firstGeneratedID(rowIDs), nil
+}
+
+func InsertAllCtx[T any](ctx context.Context, data []*T, suffix ...string) error {
+	table, err := GetTableCtx[T](ctx, suffix...)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("no data provided")
+	}
+	rows := make([]T, 0, len(data))
+	for _, d := range data {
+		if d == nil {
+			return fmt.Errorf("no data provided")
+		}
+		rows = append(rows, *d)
+	}
+	_, err = table.insertRows(ctx, nil, rows)
+	if err != nil {
+		return err
+	}
+	for i := range rows {
+		*data[i] = rows[i]
+	}
+	return nil
 }
 
 func DeleteAllCtx[T any](ctx context.Context, data []*T, suffix ...string) error {
@@ -100,7 +128,7 @@ func DeleteAllCtx[T any](ctx context.Context, data []*T, suffix ...string) error
 		return fmt.Errorf("no data provided")
 	}
 	for _, d := range data {
-		err = table.Delete(ctx, nil, *d)
+		err = table.deleteRow(ctx, nil, d)
 		if err != nil {
 			return err
 		}
@@ -116,7 +144,7 @@ func DeleteCtx[T any](ctx context.Context, data *T, suffix ...string) error {
 	if data == nil {
 		return fmt.Errorf("no data provided")
 	}
-	return table.Delete(ctx, nil, *data)
+	return table.deleteRow(ctx, nil, data)
 }
 
 func UpdateCtx[T any](ctx context.Context, data *T, suffix ...string) error {
@@ -127,16 +155,30 @@ func UpdateCtx[T any](ctx context.Context, data *T, suffix ...string) error {
 	if data == nil {
 		return fmt.Errorf("no data provided")
 	}
-	return table.Update(ctx, nil, *data)
+	return table.updateRow(ctx, nil, data)
 }
 
 func ListCtx[T any](ctx context.Context, stmt ...*WhereStmt) ([]*T, error) {
 	q := GetQuery[T](ctx)
-	q.WhereStmts = append(q.WhereStmts, stmt...)
+	// the statements belong to the caller, building the query must not change them
+	for _, w := range stmt {
+		tmp := *w
+		q.appendWhere(&tmp)
+	}
 	return q.Run(ctx, nil)
 }
 
 func GetIDCtx[T any](ctx context.Context, id string) (*T, error) {
+	if table, err := GetTableCtx[T](ctx); err == nil && table.entityCacheEnabled() && len(table.GetPrimary()) == 1 {
+		rows, err := table.GetMany(ctx, nil, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, sql.ErrNoRows
+		}
+		return rows[0], nil
+	}
 	q := GetQuery[T](ctx)
 	q.Where(q.Column("id"), "=", "AND", 0, id)
 	return q.RunSingle(ctx, nil)
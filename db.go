@@ -2,6 +2,7 @@ package QueryHelper
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/xwb1989/sqlparser"
@@ -50,7 +51,7 @@ type DB interface {
 	Ping(ctx context.Context) error
 	CreateTable(ctx context.Context, dataset, table string, columns map[string]Column) error
 	QueryContext(ctx context.Context, query string, options *DBOptions, args interface{}) (DBRow, error)
-	ExecContext(ctx context.Context, query string, args interface{}) error
+	ExecContext(ctx context.Context, query string, args interface{}) (sql.Result, error)
 	RawQueryContext(ctx context.Context, query string, options *DBOptions, args ...interface{}) (DBRow, error)
 	GetTableIndexes(database, tableName string) ([]IndexInfo, error)
 	GetTableDefinition(database string, tableName string) ([]ColumnInfo, error)
@@ -139,11 +140,24 @@ func (m MockDB) QueryContext(ctx context.Context, query string, options *DBOptio
 	return nil, nil
 }
 
-func (m MockDB) ExecContext(ctx context.Context, query string, args interface{}) error {
+func (m MockDB) ExecContext(ctx context.Context, query string, args interface{}) (sql.Result, error) {
 	if valid, err := isSQLValid(query); err != nil && !valid {
-		return fmt.Errorf("invalid query %s: %v", query, err)
+		return nil, fmt.Errorf("invalid query %s: %v", query, err)
 	}
-	return nil
+	return mockResult{}, nil
+}
+
+type mockResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r mockResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r mockResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
 }
 
 func (m MockDB) Close() {
@@ -0,0 +1,126 @@
+package QueryHelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Seann-Moser/ctx_cache"
+	"github.com/Seann-Moser/go-serve/pkg/ctxLogger"
+	"go.uber.org/zap"
+)
+
+const EntityCacheSuffix = ":entity"
+
+var ErrCompositePrimaryKey = errors.New("entity cache requires a single primary key column")
+
+// EnableEntityCache caches rows by primary key for the duration. GetMany and GetIDCtx read through the cache,
+// Update and Upsert write through to cached rows and Delete evicts them.
+func (t *Table[T]) EnableEntityCache(duration time.Duration) *Table[T] {
+	t.options.EntityCacheDuration = duration
+	return t
+}
+
+func (t *Table[T]) entityCacheEnabled() bool {
+	return t.options.EntityCacheDuration > 0
+}
+
+func (t *Table[T]) entityCacheGroup() string {
+	return t.cacheGroup() + EntityCacheSuffix
+}
+
+// GetMany returns the rows with the primary key values in the order of ids, ids that do not exist are skipped
+func (t *Table[T]) GetMany(ctx context.Context, db DB, ids ...string) ([]*T, error) {
+	primary := t.GetPrimary()
+	if len(primary) != 1 {
+		return nil, ErrCompositePrimaryKey
+	}
+	found := map[string]*T{}
+	var missing []string
+	for _, id := range ids {
+		if _, ok := found[id]; ok {
+			continue
+		}
+		if t.entityCacheEnabled() {
+			if row, err := ctx_cache.Get[T](ctx, t.entityCacheGroup(), id); err == nil && row != nil {
+				found[id] = row
+				continue
+			}
+		}
+		found[id] = nil
+		missing = append(missing, id)
+	}
+
+	if len(missing) > 0 {
+		q := QueryTable[T](t)
+		rows, err := q.Where(primary[0], "in", "AND", 0, missing).Run(ctx, db)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		for _, row := range rows {
+			key := t.RowKey(row)
+			found[key] = row
+			if t.entityCacheEnabled() {
+				_ = ctx_cache.SetWithExpiration[T](ctx, t.options.EntityCacheDuration, t.entityCacheGroup(), key, *row)
+			}
+		}
+	}
+
+	output := make([]*T, 0, len(ids))
+	for _, id := range ids {
+		if row := found[id]; row != nil {
+			output = append(output, row)
+		}
+	}
+	return output, nil
+}
+
+// writeThroughEntities replaces the cached versions of the written rows with the rows read back from the db, since
+// the db may fill in columns such as updated_timestamp. Rows that are not cached are left for the next read.
+func (t *Table[T]) writeThroughEntities(ctx context.Context, db DB, rows ...T) {
+	if !t.entityCacheEnabled() {
+		return
+	}
+	group := t.entityCacheGroup()
+	var cached []string
+	for i := range rows {
+		if row, err := ctx_cache.Get[T](ctx, group, t.RowKey(&rows[i])); err == nil && row != nil {
+			cached = append(cached, t.RowKey(&rows[i]))
+		}
+	}
+	t.evictEntities(ctx, rows...)
+	if len(cached) == 0 || len(t.GetPrimary()) != 1 {
+		return
+	}
+	// GetMany caches the rows it reads, a failed read leaves them evicted
+	if _, err := t.GetMany(ctx, db, cached...); err != nil {
+		ctxLogger.Warn(ctx, "failed writing through entity cache", zap.String("table", t.FullTableName()), zap.Error(err))
+	}
+}
+
+// EvictEntities removes the rows from the entity cache. UpdateTx and DeleteTx do not touch the cache since the
+// caller commits, call it after committing.
+func (t *Table[T]) EvictEntities(ctx context.Context, rows ...T) {
+	t.evictEntities(ctx, rows...)
+}
+
+func (t *Table[T]) evictEntities(ctx context.Context, rows ...T) {
+	if !t.entityCacheEnabled() {
+		return
+	}
+	group := t.entityCacheGroup()
+	for i := range rows {
+		key := t.RowKey(&rows[i])
+		_ = ctx_cache.Delete[T](ctx, group, key)
+		broadcastInvalidation(ctx, group, ctx_cache.GetKey[T](group, key))
+	}
+}
+
+func GetMany[T any](ctx context.Context, ids []string) ([]*T, error) {
+	table, err := GetTableCtx[T](ctx)
+	if err != nil {
+		return nil, err
+	}
+	return table.GetMany(ctx, nil, ids...)
+}
@@ -0,0 +1,161 @@
+package QueryHelper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Seann-Moser/ctx_cache"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestTable_EntityCache(t *testing.T) {
+	ctx := context.Background()
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{rowsAffected: 1}}
+	documentTable, err := NewTable[SyncedDocument]("entity", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := documentTable.EnableEntityCache(time.Minute).InitializeTable(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	group := documentTable.entityCacheGroup()
+	cached := SyncedDocument{ID: "1", Title: "old", UpdatedTimestamp: "2024-01-01 00:00:00"}
+	if err := ctx_cache.SetWithExpiration[SyncedDocument](ctx, time.Minute, group, "1", cached); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := documentTable.GetMany(ctx, db, "1", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Title != "old" {
+		t.Fatalf("expected cached row, got %v", rows)
+	}
+
+	if err := documentTable.Update(ctx, db, SyncedDocument{ID: "1", Title: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err = documentTable.GetMany(ctx, db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mock db has no rows, a row that can not be read back is evicted
+	if len(rows) != 0 {
+		t.Fatalf("expected updated row to be evicted, got %+v", rows)
+	}
+
+	if err := ctx_cache.SetWithExpiration[SyncedDocument](ctx, time.Minute, group, "1", cached); err != nil {
+		t.Fatal(err)
+	}
+	if err := documentTable.Delete(ctx, db, SyncedDocument{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err = documentTable.GetMany(ctx, db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Fatalf("expected deleted row to be evicted, got %v", rows)
+	}
+}
+
+func TestTable_EntityCacheWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	documentTable, err := NewTable[SyncedDocument]("entity_write", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documentTable.EnableEntityCache(time.Minute)
+	group := documentTable.entityCacheGroup()
+	if err := ctx_cache.SetWithExpiration[SyncedDocument](ctx, time.Minute, group, "1", SyncedDocument{ID: "1", Title: "old", UpdatedTimestamp: "2024-01-01 00:00:00"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, mock := newSqlMock(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE entity_write\.synced_document`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`FROM entity_write\.synced_document`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "updated_timestamp"}).AddRow("1", "new", "2024-01-02 00:00:00"))
+	if err := documentTable.Update(ctx, NewSql(sqlDB), SyncedDocument{ID: "1", Title: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	row, err := ctx_cache.Get[SyncedDocument](ctx, group, "1")
+	if err != nil || row == nil || row.Title != "new" || row.UpdatedTimestamp != "2024-01-02 00:00:00" {
+		t.Fatalf("expected the stored row to be written through, got %+v: %v", row, err)
+	}
+}
+
+func TestTable_EntityCacheUpdateTx(t *testing.T) {
+	ctx := context.Background()
+	documentTable, err := NewTable[SyncedDocument]("entity_tx", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documentTable.EnableEntityCache(time.Minute)
+	group := documentTable.entityCacheGroup()
+	if err := ctx_cache.SetWithExpiration[SyncedDocument](ctx, time.Minute, group, "1", SyncedDocument{ID: "1", Title: "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockDB.Close()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE entity_tx\.synced_document`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	tx, err := sqlx.NewDb(mockDB, "mysql").BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := documentTable.UpdateTx(ctx, tx, SyncedDocument{ID: "1", Title: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	// an eviction before the commit would let a read cache the old row again
+	if row, err := ctx_cache.Get[SyncedDocument](ctx, group, "1"); err != nil || row == nil {
+		t.Fatalf("expected the row to stay cached until the commit, got %+v: %v", row, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	documentTable.EvictEntities(ctx, SyncedDocument{ID: "1"})
+	if row, err := ctx_cache.Get[SyncedDocument](ctx, group, "1"); err == nil && row != nil {
+		t.Fatalf("expected the row to be evicted after the commit, got %+v", row)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetIDCtx_EntityCacheCompositeKey(t *testing.T) {
+	ctx, err := AddTableCtx[AccountUserRole](context.Background(), NewMockDB(), "entity_composite", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := GetTableCtx[AccountUserRole](ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.EnableEntityCache(time.Minute)
+	if _, err := GetIDCtx[AccountUserRole](ctx, "1"); errors.Is(err, ErrCompositePrimaryKey) {
+		t.Fatal("expected composite key tables to skip the entity cache")
+	}
+}
+
+func TestTable_GetManyCompositeKey(t *testing.T) {
+	roleTable, err := NewTable[AccountUserRole]("entity", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := roleTable.GetMany(context.Background(), NewMockDB(), "1"); err != ErrCompositePrimaryKey {
+		t.Fatalf("expected ErrCompositePrimaryKey, got %v", err)
+	}
+}
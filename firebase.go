@@ -2,6 +2,7 @@ package QueryHelper
 
 import (
 	"context"
+	"database/sql"
 )
 
 var _ DB = &FirebaseDB{}
@@ -58,7 +59,7 @@ func (f FirebaseDB) QueryContext(ctx context.Context, query string, options *DBO
 	panic("implement me")
 }
 
-func (f FirebaseDB) ExecContext(ctx context.Context, query string, args interface{}) error {
+func (f FirebaseDB) ExecContext(ctx context.Context, query string, args interface{}) (sql.Result, error) {
 	//TODO implement me
 	panic("implement me")
 }
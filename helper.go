@@ -39,6 +39,76 @@ func GetValueFromStructArray[T any](key string, arr ...T) []string {
 	return output
 }
 
+// SetGeneratedIDs writes generated ids back into the db tagged fields of the struct pointed to by data
+func SetGeneratedIDs(data interface{}, ids map[string]string) error {
+	return setColumnValues(data, ids)
+}
+
+// columnValues returns the field values of the struct pointed to by data, keyed by column name
+func columnValues(data interface{}) map[string]interface{} {
+	output := map[string]interface{}{}
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return output
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return output
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Tag.Get(TagColumnNamePrefix)
+		if name == "" {
+			name = ToSnakeCase(field.Name)
+		}
+		output[name] = v.Field(i).Interface()
+	}
+	return output
+}
+
+// setColumnValues writes values into the fields of the struct pointed to by data, matching fields by column name
+func setColumnValues(data interface{}, values map[string]string) error {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected pointer to struct, got %T", data)
+	}
+	s := v.Elem()
+	for i := 0; i < s.NumField(); i++ {
+		field := s.Type().Field(i)
+		name := field.Tag.Get(TagColumnNamePrefix)
+		if name == "" {
+			name = ToSnakeCase(field.Name)
+		}
+		value, found := values[name]
+		if !found || !s.Field(i).CanSet() {
+			continue
+		}
+		f := s.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed setting value for %s: %w", name, err)
+			}
+			f.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed setting value for %s: %w", name, err)
+			}
+			f.SetUint(n)
+		}
+	}
+	return nil
+}
+
 func safeString(d interface{}) string {
 	switch v := d.(type) {
 	case string:
@@ -49,8 +119,10 @@ func safeString(d interface{}) string {
 		return strconv.Itoa(int(v))
 	case int32:
 		return strconv.Itoa(int(v))
-	case float32, float64:
-		return fmt.Sprintf("%d", v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
 	case bool:
 		return strconv.FormatBool(v)
 	}
@@ -68,10 +140,6 @@ func fixArrays(query string, args map[string]interface{}) string {
 			if s == "" {
 				continue
 			}
-			if strings.HasPrefix(s, "SELECT") {
-				query = strings.ReplaceAll(query, namedArg, fmt.Sprintf("(%s)", s))
-				continue
-			}
 			newArgs := []string{}
 			switch t := v.(type) {
 			case []interface{}:
@@ -0,0 +1,289 @@
+package QueryHelper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	HistoryTableSuffix  = "_history"
+	HistoryKeySeparator = ":"
+
+	// the operations stored in HistoryRow.Operation
+	HistoryOperationUpdate = string(OperationUpdate)
+	HistoryOperationUpsert = string(OperationUpsert)
+	HistoryOperationDelete = string(OperationDelete)
+)
+
+var (
+	ErrHistoryNotEnabled          = errors.New("history is not enabled for table")
+	ErrHistoryRequiresTransaction = errors.New("history requires a database that can begin transactions")
+)
+
+// Transactor is implemented by databases that can begin a transaction, writes to tables with history need it
+type Transactor interface {
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
+}
+
+// TableOptions configures optional table features, structs can implement TableOptioner to set them
+type TableOptions struct {
+	// History keeps every change to a row in a companion <table>_history table, written in the transaction of the
+	// change. Writes without a transaction need a db that implements Transactor.
+	History bool
+	// HistoryDiff stores a json diff of the changed fields on update instead of the full before-image
+	HistoryDiff bool
+	// Outbox writes an event to the dataset outbox table for every change made inside a transaction
+	Outbox bool
+	// EntityCacheDuration caches rows by primary key when it is set
+	EntityCacheDuration time.Duration
+}
+
+type TableOptioner interface {
+	TableOptions() TableOptions
+}
+
+// HistoryRow is a single change stored in a history table. Data holds the before-image of the row,
+// or a diff of {"column": {"old": ..., "new": ...}} for updates when HistoryDiff is enabled.
+type HistoryRow struct {
+	ID               int64  `json:"history_id" db:"history_id" qc:"primary;auto_generate_id;order;order_asc"`
+	RowKey           string `json:"row_key" db:"row_key" qc:"data_type::varchar(512)"`
+	Operation        string `json:"operation" db:"operation" qc:"data_type::varchar(16)"`
+	Actor            string `json:"actor" db:"actor" qc:"data_type::varchar(256)"`
+	Diff             bool   `json:"diff" db:"diff"`
+	Data             string `json:"data" db:"data" qc:"data_type::json"`
+	ChangedTimestamp string `json:"changed_timestamp" db:"changed_timestamp" qc:"skip;default::created_timestamp"`
+}
+
+func (h *HistoryRow) Unmarshal(v interface{}) error {
+	return json.Unmarshal([]byte(h.Data), v)
+}
+
+// EnableHistory turns on history for the table, it must be called before InitializeTable
+func (t *Table[T]) EnableHistory(diff bool) *Table[T] {
+	t.options.History = true
+	t.options.HistoryDiff = diff
+	return t
+}
+
+func (t *Table[T]) HistoryTableName() string {
+	return t.Name + HistoryTableSuffix
+}
+
+func (t *Table[T]) initializeHistory(ctx context.Context, db DB) error {
+	if !t.options.History {
+		return nil
+	}
+	historyTable, err := newInternalTable[HistoryRow](ctx, db, t.Dataset, t.HistoryTableName(), t.QueryType)
+	if err != nil {
+		return fmt.Errorf("failed creating history table %s: %w", t.HistoryTableName(), err)
+	}
+	t.historyTable = historyTable
+	return nil
+}
+
+// newInternalTable creates a table for one of the library structs under the given name
+func newInternalTable[X any](ctx context.Context, db DB, dataset, name string, queryType QueryType) (*Table[X], error) {
+	table, err := NewTable[X](dataset, queryType)
+	if err != nil {
+		return nil, err
+	}
+	table.Name = name
+	for k, c := range table.Columns {
+		c.Table = name
+		table.Columns[k] = c
+	}
+	table.db = db
+	if err := db.CreateTable(ctx, db.GetDataset(dataset), name, table.Columns); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// RowKey joins the primary key values of the row in column order, it identifies the row in the history table
+func (t *Table[T]) RowKey(row *T) string {
+	values := columnValues(row)
+	primary := t.GetPrimary()
+	sort.Slice(primary, func(i, j int) bool {
+		return primary[i].ColumnOrder < primary[j].ColumnOrder
+	})
+	var keys []string
+	for _, c := range primary {
+		keys = append(keys, safeString(values[c.Name]))
+	}
+	return strings.Join(keys, HistoryKeySeparator)
+}
+
+// lockByPrimary loads the stored version of the row using its primary key values and locks it until tx ends
+func (t *Table[T]) lockByPrimary(ctx context.Context, tx *sqlx.Tx, row *T) (*T, error) {
+	values := columnValues(row)
+	q := QueryTable[T](t)
+	for _, c := range t.GetPrimary() {
+		q.Where(c, "=", "AND", 0, values[c.Name])
+	}
+	q = q.Build()
+	if q.err != nil {
+		return nil, q.err
+	}
+	rows, err := sqlx.NamedQueryContext(ctx, tx, strings.TrimSuffix(q.Query, ";")+"\nFOR UPDATE", q.Args())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var current T
+	if err := rows.StructScan(&current); err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+func (t *Table[T]) beforeImages(ctx context.Context, tx *sqlx.Tx, rows []T) ([]*T, error) {
+	images := make([]*T, len(rows))
+	for i := range rows {
+		before, err := t.lockByPrimary(ctx, tx, &rows[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed loading history before-image: %w", err)
+		}
+		images[i] = before
+	}
+	return images, nil
+}
+
+// execWrite runs the write statement for rows. With history the before-images are locked, the write is made and
+// the history rows are written in one transaction, so a change is never committed without its history.
+func (t *Table[T]) execWrite(ctx context.Context, db DB, operation Operation, rows []T, query string, args interface{}) (sql.Result, error) {
+	if !t.options.History || t.historyTable == nil {
+		return db.ExecContext(ctx, query, args)
+	}
+	transactor, ok := db.(Transactor)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrHistoryRequiresTransaction, t.FullTableName())
+	}
+	tx, err := transactor.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	result, err := t.execWriteTx(ctx, tx, operation, rows, query, args)
+	if err == nil && (operation == OperationUpdate || operation == OperationDelete) {
+		err = t.checkRowsAffected(result)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return result, nil
+}
+
+// execWriteTx runs the write statement for rows with the callers transaction, writing their history when it is enabled
+func (t *Table[T]) execWriteTx(ctx context.Context, tx *sqlx.Tx, operation Operation, rows []T, query string, args interface{}) (sql.Result, error) {
+	if !t.options.History || t.historyTable == nil {
+		return tx.NamedExecContext(ctx, query, args)
+	}
+	before, err := t.beforeImages(ctx, tx, rows)
+	if err != nil {
+		return nil, err
+	}
+	result, err := tx.NamedExecContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.writeHistory(ctx, tx, operation, rows, before); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (t *Table[T]) writeHistory(ctx context.Context, tx *sqlx.Tx, operation Operation, rows []T, before []*T) error {
+	if !t.options.History || t.historyTable == nil || len(rows) == 0 {
+		return nil
+	}
+	actor, _ := GetActor(ctx)
+	var historyRows []HistoryRow
+	for i := range rows {
+		var image *T
+		if i < len(before) {
+			image = before[i]
+		}
+		historyRow := HistoryRow{
+			RowKey:    t.RowKey(&rows[i]),
+			Operation: string(operation),
+			Actor:     actor,
+		}
+		var data interface{} = image
+		if t.options.HistoryDiff && operation != OperationDelete && image != nil {
+			diff, err := diffRows(image, &rows[i])
+			if err != nil {
+				return err
+			}
+			data = diff
+			historyRow.Diff = true
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		historyRow.Data = string(b)
+		historyRows = append(historyRows, historyRow)
+	}
+	args, err := combineStructsWithPrefix[HistoryRow](historyRows...)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.NamedExecContext(ctx, t.historyTable.insertStatement(len(historyRows), true), args); err != nil {
+		return fmt.Errorf("failed writing history for %s: %w", t.FullTableName(), err)
+	}
+	return nil
+}
+
+// History returns the changes made to the row with the primary key values, oldest first. It is always read from
+// the db, writes to the table do not invalidate cached history.
+func (t *Table[T]) History(ctx context.Context, db DB, key ...string) ([]*HistoryRow, error) {
+	if t.historyTable == nil {
+		return nil, ErrHistoryNotEnabled
+	}
+	q := QueryTable[HistoryRow](t.historyTable)
+	return q.Where(q.Column("row_key"), "=", "AND", 0, strings.Join(key, HistoryKeySeparator)).
+		OrderBy(q.Column("history_id")).
+		SkipCache().
+		Run(ctx, db)
+}
+
+func History[T any](ctx context.Context, key ...string) ([]*HistoryRow, error) {
+	table, err := GetTableCtx[T](ctx)
+	if err != nil {
+		return nil, err
+	}
+	return table.History(ctx, nil, key...)
+}
+
+func diffRows(before, after interface{}) (map[string]map[string]interface{}, error) {
+	b, err := combineStructs(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := combineStructs(after)
+	if err != nil {
+		return nil, err
+	}
+	diff := map[string]map[string]interface{}{}
+	for k, v := range a {
+		if !reflect.DeepEqual(b[k], v) {
+			diff[k] = map[string]interface{}{"old": b[k], "new": v}
+		}
+	}
+	return diff, nil
+}
@@ -0,0 +1,235 @@
+package QueryHelper
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+type VersionedDocument struct {
+	ID    string `json:"id" db:"id" qc:"primary"`
+	Title string `json:"title" db:"title" qc:"update"`
+}
+
+func (VersionedDocument) TableOptions() TableOptions {
+	return TableOptions{History: true}
+}
+
+// recordedArgs is a sqlmock argument that accepts any value and keeps it
+type recordedArgs []driver.Value
+
+func (r *recordedArgs) Match(v driver.Value) bool {
+	*r = append(*r, v)
+	return true
+}
+
+func (r *recordedArgs) matchers(n int) []driver.Value {
+	args := make([]driver.Value, n)
+	for i := range args {
+		args[i] = r
+	}
+	return args
+}
+
+func (r recordedArgs) contains(value string) bool {
+	for _, v := range r {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func newHistoryTable(t *testing.T, ctx context.Context) *Table[VersionedDocument] {
+	db := NewMockDB()
+	documentTable, err := NewTable[VersionedDocument]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := documentTable.InitializeTable(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := db.tables["test.versioned_document_history"]; !found {
+		t.Fatal("expected history table to be created")
+	}
+	return documentTable
+}
+
+func newSqlMock(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = mockDB.Close() })
+	return sqlx.NewDb(mockDB, "mysql"), mock
+}
+
+func TestHistory_Update(t *testing.T) {
+	ctx := WithActor(context.Background(), "user-1")
+	documentTable := newHistoryTable(t, ctx)
+	sqlDB, mock := newSqlMock(t)
+
+	var history recordedArgs
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT (.|\n)+FROM(.|\n)+test\.versioned_document(.|\n)+FOR UPDATE`).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow("1", "old"))
+	mock.ExpectExec(`UPDATE test\.versioned_document`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO test\.versioned_document_history`).
+		WithArgs(history.matchers(5)...).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := documentTable.Update(ctx, NewSql(sqlDB), VersionedDocument{ID: "1", Title: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if !history.contains(string(OperationUpdate)) || !history.contains("user-1") || !history.contains("1") {
+		t.Fatalf("unexpected history row %v", history)
+	}
+}
+
+func TestHistory_RollbackOnHistoryError(t *testing.T) {
+	ctx := context.Background()
+	documentTable := newHistoryTable(t, ctx)
+	sqlDB, mock := newSqlMock(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE`).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow("1", "old"))
+	mock.ExpectExec(`DELETE FROM test\.versioned_document`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO test\.versioned_document_history`).WillReturnError(errors.New("history failed"))
+	mock.ExpectRollback()
+
+	if err := documentTable.Delete(ctx, NewSql(sqlDB), VersionedDocument{ID: "1"}); err == nil {
+		t.Fatal("expected the history error")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistory_UpdateTx(t *testing.T) {
+	ctx := context.Background()
+	documentTable := newHistoryTable(t, ctx)
+	sqlDB, mock := newSqlMock(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE`).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow("1", "old"))
+	mock.ExpectExec(`UPDATE test\.versioned_document`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO test\.versioned_document_history`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	tx, err := sqlDB.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := documentTable.UpdateTx(ctx, tx, VersionedDocument{ID: "1", Title: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistory_RequiresTransaction(t *testing.T) {
+	ctx := context.Background()
+	documentTable := newHistoryTable(t, ctx)
+	err := documentTable.Update(ctx, NewMockDB(), VersionedDocument{ID: "1", Title: "new"})
+	if !errors.Is(err, ErrHistoryRequiresTransaction) {
+		t.Fatalf("expected ErrHistoryRequiresTransaction, got %v", err)
+	}
+}
+
+func TestHistory_NotEnabled(t *testing.T) {
+	resourceTable, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resourceTable.History(context.Background(), nil, "1"); err != ErrHistoryNotEnabled {
+		t.Fatalf("expected ErrHistoryNotEnabled, got %v", err)
+	}
+}
+
+func TestDiffRows(t *testing.T) {
+	diff, err := diffRows(&VersionedDocument{ID: "1", Title: "old"}, &VersionedDocument{ID: "1", Title: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 || diff["title"]["old"] != "old" || diff["title"]["new"] != "new" {
+		t.Fatalf("unexpected diff %v", diff)
+	}
+}
+
+type GeneratedDocument struct {
+	ID    string `json:"id" db:"id" qc:"primary;auto_generate_id;auto_generate_id_type::hex"`
+	Title string `json:"title" db:"title" qc:"update"`
+}
+
+func (GeneratedDocument) TableOptions() TableOptions {
+	return TableOptions{History: true}
+}
+
+func TestHistory_UpsertGeneratedID(t *testing.T) {
+	ctx := context.Background()
+	documentTable, err := NewTable[GeneratedDocument]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := documentTable.InitializeTable(ctx, NewMockDB()); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, mock := newSqlMock(t)
+
+	var history recordedArgs
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE`).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}))
+	mock.ExpectExec(`INSERT INTO test\.generated_document\(`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO test\.generated_document_history`).
+		WithArgs(history.matchers(5)...).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	id, err := documentTable.Upsert(ctx, NewSql(sqlDB), GeneratedDocument{Title: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" || !history.contains(id) {
+		t.Fatalf("expected the history row to be keyed by the generated id %q, got %v", id, history)
+	}
+
+	mock.ExpectQuery(`FROM test\.generated_document_history`).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"history_id", "row_key", "operation", "actor", "diff", "data", "changed_timestamp"}).
+			AddRow(1, id, string(OperationUpsert), "", false, "null", "2024-01-02 03:04:05"))
+	rows, err := documentTable.History(ctx, NewSql(sqlDB), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].RowKey != id {
+		t.Fatalf("unexpected history %v", rows)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistory_InsertOmitsAutoIncrementID(t *testing.T) {
+	documentTable := newHistoryTable(t, context.Background())
+	statement := documentTable.historyTable.insertStatement(1, true)
+	if strings.Contains(statement, "history_id") || !strings.Contains(statement, "row_key") {
+		t.Fatalf("expected the db to generate history_id, got %s", statement)
+	}
+	if !strings.Contains(documentTable.historyTable.InsertStatement(1), "history_id") {
+		t.Fatal("expected InsertStatement to keep the AUTO_INCREMENT column")
+	}
+}
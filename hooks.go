@@ -0,0 +1,104 @@
+package QueryHelper
+
+import (
+	"context"
+	"fmt"
+)
+
+// Lifecycle hooks can be implemented on table structs with a pointer receiver. Before hooks run
+// before the row is serialized, so they can modify the row or abort the operation by returning an error.
+// Upsert runs the insert hooks. AfterFind runs when rows are scanned from the database, not for cached results.
+//
+// After hooks run once the write succeeded. Insert, Update, Delete and Upsert have already committed the change
+// when an after hook runs, so an error returned by it is reported to the caller but does not undo the write. The
+// Tx variants run the after hooks before the caller commits, the caller decides whether to roll back.
+
+type BeforeInserter interface {
+	BeforeInsert(ctx context.Context) error
+}
+
+// AfterInserter runs after rows are inserted or upserted
+type AfterInserter interface {
+	AfterInsert(ctx context.Context) error
+}
+
+type BeforeUpdater interface {
+	BeforeUpdate(ctx context.Context) error
+}
+
+// AfterUpdater runs after a row is updated
+type AfterUpdater interface {
+	AfterUpdate(ctx context.Context) error
+}
+
+type BeforeDeleter interface {
+	BeforeDelete(ctx context.Context) error
+}
+
+// AfterDeleter runs after a row is deleted
+type AfterDeleter interface {
+	AfterDelete(ctx context.Context) error
+}
+
+type AfterFinder interface {
+	AfterFind(ctx context.Context) error
+}
+
+type hookType string
+
+const (
+	hookBeforeInsert hookType = "before insert"
+	hookAfterInsert  hookType = "after insert"
+	hookBeforeUpdate hookType = "before update"
+	hookAfterUpdate  hookType = "after update"
+	hookBeforeDelete hookType = "before delete"
+	hookAfterDelete  hookType = "after delete"
+	hookAfterFind    hookType = "after find"
+)
+
+func runHook(ctx context.Context, hook hookType, row interface{}) error {
+	var err error
+	switch hook {
+	case hookBeforeInsert:
+		if h, ok := row.(BeforeInserter); ok {
+			err = h.BeforeInsert(ctx)
+		}
+	case hookAfterInsert:
+		if h, ok := row.(AfterInserter); ok {
+			err = h.AfterInsert(ctx)
+		}
+	case hookBeforeUpdate:
+		if h, ok := row.(BeforeUpdater); ok {
+			err = h.BeforeUpdate(ctx)
+		}
+	case hookAfterUpdate:
+		if h, ok := row.(AfterUpdater); ok {
+			err = h.AfterUpdate(ctx)
+		}
+	case hookBeforeDelete:
+		if h, ok := row.(BeforeDeleter); ok {
+			err = h.BeforeDelete(ctx)
+		}
+	case hookAfterDelete:
+		if h, ok := row.(AfterDeleter); ok {
+			err = h.AfterDelete(ctx)
+		}
+	case hookAfterFind:
+		if h, ok := row.(AfterFinder); ok {
+			err = h.AfterFind(ctx)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %w", hook, err)
+	}
+	return nil
+}
+
+func runHooks[T any](ctx context.Context, hook hookType, rows []T) error {
+	for i := range rows {
+		if err := runHook(ctx, hook, &rows[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package QueryHelper
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var errInvalidName = errors.New("name is required")
+
+type HookedUser struct {
+	ID    string `json:"id" db:"id" qc:"primary;auto_generate_id"`
+	Name  string `json:"name" db:"name" qc:"update"`
+	Email string `json:"email" db:"email" qc:"update"`
+
+	afterInsertID string
+}
+
+func (u *HookedUser) BeforeInsert(ctx context.Context) error {
+	if u.Name == "" {
+		return errInvalidName
+	}
+	u.Email = u.Name + "@example.com"
+	return nil
+}
+
+func (u *HookedUser) AfterInsert(ctx context.Context) error {
+	u.afterInsertID = u.ID
+	return nil
+}
+
+func (u *HookedUser) BeforeUpdate(ctx context.Context) error {
+	if u.Name == "" {
+		return errInvalidName
+	}
+	return nil
+}
+
+func TestHooks_BeforeInsert(t *testing.T) {
+	userTable, err := NewTable[HookedUser]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{rowsAffected: 1}}
+	ctx := context.Background()
+
+	if _, err := userTable.Insert(ctx, db, HookedUser{}); !errors.Is(err, errInvalidName) {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+	if len(db.queries) != 0 {
+		t.Fatalf("expected insert to be aborted, got %d queries", len(db.queries))
+	}
+
+	id, err := userTable.Insert(ctx, db, HookedUser{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := db.args[0].(map[string]interface{})
+	if args["0_email"] != "test@example.com" {
+		t.Fatalf("expected hook to modify the row before serializing, got %v", args["0_email"])
+	}
+	if id == "" {
+		t.Fatal("expected generated id")
+	}
+}
+
+func TestHooks_UpdateAbort(t *testing.T) {
+	userTable, err := NewTable[HookedUser]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{rowsAffected: 1}}
+	if err := userTable.Update(context.Background(), db, HookedUser{ID: "1"}); !errors.Is(err, errInvalidName) {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+	if len(db.queries) != 0 {
+		t.Fatalf("expected update to be aborted, got %d queries", len(db.queries))
+	}
+}
+
+func TestHooks_InsertCtx(t *testing.T) {
+	ctx := context.Background()
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{rowsAffected: 1}}
+	ctx, err := AddTableCtx[HookedUser](ctx, db, "test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &HookedUser{Name: "ctx"}
+	id, err := InsertCtx(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != id || user.afterInsertID != id {
+		t.Fatalf("expected generated id %s to be written back, got %s/%s", id, user.ID, user.afterInsertID)
+	}
+	if user.Email != "ctx@example.com" {
+		t.Fatalf("expected hook changes to be written back, got %s", user.Email)
+	}
+}
@@ -0,0 +1,213 @@
+package QueryHelper
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	IDTypeUUID      = "uuid"
+	IDTypeHex       = "hex"
+	IDTypeBase64    = "base64"
+	IDTypeULID      = "ulid"
+	IDTypeUUIDv7    = "uuidv7"
+	IDTypeKSUID     = "ksuid"
+	IDTypeSnowflake = "snowflake"
+)
+
+var (
+	ErrInvalidSnowflakeNode = errors.New("snowflake node id must be between 0 and 1023")
+	ErrUnknownIDGenerator   = errors.New("unknown auto_generate_id_type")
+
+	idGeneratorsMutex = &sync.RWMutex{}
+	idGenerators      = map[string]IDGenerator{}
+	defaultSnowflake  = &Snowflake{}
+)
+
+// IDGenerator returns a new unique id every time it is called
+type IDGenerator func() string
+
+func init() {
+	RegisterIDGenerator(IDTypeUUID, func() string {
+		return uuid.New().String()
+	})
+	RegisterIDGenerator(IDTypeHex, func() string {
+		hasher := sha1.New()
+		hasher.Write([]byte(uuid.New().String()))
+		return hex.EncodeToString(hasher.Sum(nil))
+	})
+	RegisterIDGenerator(IDTypeBase64, func() string {
+		hasher := sha1.New()
+		hasher.Write([]byte(uuid.New().String()))
+		return base64.URLEncoding.EncodeToString(hasher.Sum(nil))
+	})
+	RegisterIDGenerator(IDTypeULID, NewULID)
+	RegisterIDGenerator(IDTypeUUIDv7, func() string {
+		return uuid.Must(uuid.NewV7()).String()
+	})
+	RegisterIDGenerator(IDTypeKSUID, NewKSUID)
+	RegisterIDGenerator(IDTypeSnowflake, func() string {
+		return defaultSnowflake.Generate()
+	})
+}
+
+// RegisterIDGenerator makes a generator selectable with auto_generate_id_type::<name>, it must be registered before
+// the tables using it are created
+func RegisterIDGenerator(name string, generator IDGenerator) {
+	idGeneratorsMutex.Lock()
+	defer idGeneratorsMutex.Unlock()
+	idGenerators[strings.ToLower(name)] = generator
+}
+
+// GetIDGenerator returns the generator registered under name, falling back to uuid
+func GetIDGenerator(name string) IDGenerator {
+	idGeneratorsMutex.RLock()
+	defer idGeneratorsMutex.RUnlock()
+	if generator, found := idGenerators[strings.ToLower(name)]; found {
+		return generator
+	}
+	return idGenerators[IDTypeUUID]
+}
+
+func hasIDGenerator(name string) bool {
+	idGeneratorsMutex.RLock()
+	defer idGeneratorsMutex.RUnlock()
+	_, found := idGenerators[strings.ToLower(name)]
+	return found
+}
+
+// SetSnowflakeNode sets the node id used by the snowflake generator, it must be unique per running instance
+func SetSnowflakeNode(node int64) error {
+	if node < 0 || node > snowflakeMaxNode {
+		return ErrInvalidSnowflakeNode
+	}
+	defaultSnowflake.mutex.Lock()
+	defer defaultSnowflake.mutex.Unlock()
+	defaultSnowflake.node = node
+	return nil
+}
+
+const (
+	crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	base62Alphabet    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	ksuidEpoch  = 1400000000
+	ksuidLength = 27
+
+	snowflakeEpoch        = 1288834974657
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	snowflakeMaxNode      = -1 ^ (-1 << snowflakeNodeBits)
+	snowflakeMaxSequence  = -1 ^ (-1 << snowflakeSequenceBits)
+)
+
+var ulidState = struct {
+	mutex  sync.Mutex
+	lastMs uint64
+	last   [10]byte
+}{}
+
+// NewULID returns a 26 character, lexicographically sortable ULID. Ids generated within
+// the same millisecond increment the random component so they stay ordered.
+func NewULID() string {
+	ulidState.mutex.Lock()
+	defer ulidState.mutex.Unlock()
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= ulidState.lastMs {
+		ms = ulidState.lastMs
+		for i := len(ulidState.last) - 1; i >= 0; i-- {
+			ulidState.last[i]++
+			if ulidState.last[i] != 0 {
+				break
+			}
+		}
+	} else {
+		_, _ = rand.Read(ulidState.last[:])
+	}
+	ulidState.lastMs = ms
+
+	var data [16]byte
+	data[0] = byte(ms >> 40)
+	data[1] = byte(ms >> 32)
+	data[2] = byte(ms >> 24)
+	data[3] = byte(ms >> 16)
+	data[4] = byte(ms >> 8)
+	data[5] = byte(ms)
+	copy(data[6:], ulidState.last[:])
+
+	// 128 bits are encoded as 26 base32 characters, the first holding the top 3 bits
+	value := new(big.Int).SetBytes(data[:])
+	output := make([]byte, 26)
+	mask := big.NewInt(31)
+	for i := len(output) - 1; i >= 0; i-- {
+		output[i] = crockfordAlphabet[new(big.Int).And(value, mask).Int64()]
+		value.Rsh(value, 5)
+	}
+	return string(output)
+}
+
+// NewKSUID returns a 27 character KSUID, a second precision timestamp followed by 128 random bits
+func NewKSUID() string {
+	var data [20]byte
+	binary.BigEndian.PutUint32(data[:4], uint32(time.Now().Unix()-ksuidEpoch))
+	_, _ = rand.Read(data[4:])
+
+	value := new(big.Int).SetBytes(data[:])
+	base := big.NewInt(62)
+	mod := new(big.Int)
+	output := make([]byte, ksuidLength)
+	for i := ksuidLength - 1; i >= 0; i-- {
+		value.DivMod(value, base, mod)
+		output[i] = base62Alphabet[mod.Int64()]
+	}
+	return string(output)
+}
+
+// Snowflake generates 64-bit time ordered ids made of a millisecond timestamp, a node id and a sequence
+type Snowflake struct {
+	mutex    sync.Mutex
+	node     int64
+	lastMs   int64
+	sequence int64
+}
+
+func NewSnowflake(node int64) (*Snowflake, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, ErrInvalidSnowflakeNode
+	}
+	return &Snowflake{node: node}, nil
+}
+
+// Generate returns the next id as a decimal string. If the clock moves backwards or the
+// sequence is exhausted the previous timestamp is reused or advanced, so ids never repeat.
+func (s *Snowflake) Generate() string {
+	return strconv.FormatInt(s.Next(), 10)
+}
+
+func (s *Snowflake) Next() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ms := time.Now().UnixMilli() - snowflakeEpoch
+	if ms <= s.lastMs {
+		ms = s.lastMs
+		s.sequence = (s.sequence + 1) & snowflakeMaxSequence
+		if s.sequence == 0 {
+			ms++
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastMs = ms
+	return ms<<(snowflakeNodeBits+snowflakeSequenceBits) | s.node<<snowflakeSequenceBits | s.sequence
+}
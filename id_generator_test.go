@@ -0,0 +1,107 @@
+package QueryHelper
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestGetIDGenerator(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+	}{
+		{name: IDTypeUUID, pattern: `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}$`},
+		{name: IDTypeHex, pattern: `^[0-9a-f]{40}$`},
+		{name: IDTypeBase64, pattern: `^[A-Za-z0-9_=-]{28}$`},
+		{name: IDTypeULID, pattern: `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`},
+		{name: IDTypeUUIDv7, pattern: `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}$`},
+		{name: IDTypeKSUID, pattern: `^[0-9A-Za-z]{27}$`},
+		{name: IDTypeSnowflake, pattern: `^[0-9]+$`},
+		{name: "unknown", pattern: `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := GetIDGenerator(tt.name)()
+			if !regexp.MustCompile(tt.pattern).MatchString(id) {
+				t.Errorf("GetIDGenerator(%s)() = %s, does not match %s", tt.name, id, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestNewULIDOrdered(t *testing.T) {
+	var ids []string
+	for i := 0; i < 1000; i++ {
+		ids = append(ids, NewULID())
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Error("expected ulids generated in sequence to be sorted")
+	}
+}
+
+func TestSnowflake(t *testing.T) {
+	if _, err := NewSnowflake(1024); err == nil {
+		t.Fatal("expected error for node out of range")
+	}
+	s, err := NewSnowflake(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var previous int64
+	seen := map[int64]struct{}{}
+	for i := 0; i < 10000; i++ {
+		id := s.Next()
+		if id <= previous {
+			t.Fatalf("expected increasing ids, got %d after %d", id, previous)
+		}
+		if (id>>snowflakeSequenceBits)&snowflakeMaxNode != 7 {
+			t.Fatalf("expected node 7 in id %d", id)
+		}
+		seen[id] = struct{}{}
+		previous = id
+	}
+	if len(seen) != 10000 {
+		t.Fatalf("expected 10000 unique ids, got %d", len(seen))
+	}
+}
+
+func TestRegisterIDGenerator(t *testing.T) {
+	var counter int
+	RegisterIDGenerator("counter", func() string {
+		counter++
+		return strconv.Itoa(counter)
+	})
+	if id := GetIDGenerator("counter")(); id != "1" {
+		t.Fatalf("expected custom generator to be used, got %s", id)
+	}
+}
+
+type UnknownIDRow struct {
+	ID string `json:"id" db:"id" qc:"primary;auto_generate_id;auto_generate_id_type::missing"`
+}
+
+func TestNewTable_UnknownIDGenerator(t *testing.T) {
+	if _, err := NewTable[UnknownIDRow]("test", QueryTypeSQL); !errors.Is(err, ErrUnknownIDGenerator) {
+		t.Fatalf("expected unknown id generator to be rejected, got %v", err)
+	}
+}
+
+func TestNewSql_KeepsSnowflakeNode(t *testing.T) {
+	if err := SetSnowflakeNode(9); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = SetSnowflakeNode(0) }()
+	db, _ := newCountingDB()
+	defer db.Close()
+	NewSql(db)
+	id, err := strconv.ParseInt(GetIDGenerator(IDTypeSnowflake)(), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (id>>snowflakeSequenceBits)&snowflakeMaxNode != 9 {
+		t.Fatalf("expected NewSql to keep the configured node, got id %d", id)
+	}
+}
@@ -0,0 +1,194 @@
+package QueryHelper
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Seann-Moser/ctx_cache"
+	"github.com/Seann-Moser/go-serve/pkg/ctxLogger"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultInvalidationChannel = "query_helper_invalidation"
+
+	invalidationDedupWindow = time.Minute
+)
+
+// InvalidationMessage tells other instances to drop the cached entries of a cache group,
+// or only the entry stored under Key when it is set
+type InvalidationMessage struct {
+	ID     string `json:"id"`
+	Origin string `json:"origin"`
+	Group  string `json:"group"`
+	Key    string `json:"key,omitempty"`
+}
+
+// InvalidationBroadcaster fans cache group invalidations out to every running instance
+type InvalidationBroadcaster interface {
+	Publish(ctx context.Context, message InvalidationMessage) error
+	// Subscribe calls handler for every message published by any instance until ctx is done
+	Subscribe(ctx context.Context, handler func(ctx context.Context, message InvalidationMessage)) error
+}
+
+var (
+	invalidationMutex                               = &sync.RWMutex{}
+	invalidationBroadcaster InvalidationBroadcaster = NoopBroadcaster{}
+	invalidationInstance                            = uuid.New().String()
+	invalidationSeen                                = newMessageDedup(invalidationDedupWindow)
+)
+
+// SetInvalidationBroadcaster replaces the default no-op broadcaster, ListenForInvalidations must run to apply remote invalidations
+func SetInvalidationBroadcaster(broadcaster InvalidationBroadcaster) {
+	invalidationMutex.Lock()
+	defer invalidationMutex.Unlock()
+	if broadcaster == nil {
+		broadcaster = NoopBroadcaster{}
+	}
+	invalidationBroadcaster = broadcaster
+}
+
+func getInvalidationBroadcaster() InvalidationBroadcaster {
+	invalidationMutex.RLock()
+	defer invalidationMutex.RUnlock()
+	return invalidationBroadcaster
+}
+
+// InvalidateCacheGroup deletes the cache group locally and broadcasts the invalidation to the other instances
+func InvalidateCacheGroup(ctx context.Context, group string) {
+	_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, group)
+	broadcastInvalidation(ctx, group, "")
+}
+
+func broadcastInvalidation(ctx context.Context, group, key string) {
+	message := InvalidationMessage{
+		ID:     uuid.New().String(),
+		Origin: invalidationInstance,
+		Group:  group,
+		Key:    key,
+	}
+	if err := getInvalidationBroadcaster().Publish(ctx, message); err != nil {
+		ctxLogger.Warn(ctx, "failed broadcasting cache invalidation", zap.String("group", group), zap.Error(err))
+	}
+}
+
+// ListenForInvalidations applies invalidations published by other instances until ctx is done.
+// Messages sent by this instance and messages that were already applied are ignored.
+func ListenForInvalidations(ctx context.Context) error {
+	return getInvalidationBroadcaster().Subscribe(ctx, applyInvalidation)
+}
+
+func applyInvalidation(ctx context.Context, message InvalidationMessage) {
+	if message.Origin == invalidationInstance || message.Group == "" {
+		return
+	}
+	if !invalidationSeen.add(message.ID) {
+		return
+	}
+	if message.Key != "" {
+		_ = ctx_cache.DeleteKey(ctx, message.Key)
+		return
+	}
+	_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, message.Group)
+}
+
+// NoopBroadcaster is the default broadcaster, invalidations stay in the local process
+type NoopBroadcaster struct{}
+
+func (NoopBroadcaster) Publish(ctx context.Context, message InvalidationMessage) error {
+	return nil
+}
+
+func (NoopBroadcaster) Subscribe(ctx context.Context, handler func(ctx context.Context, message InvalidationMessage)) error {
+	<-ctx.Done()
+	return nil
+}
+
+// RedisBroadcaster sends invalidations over a redis pub/sub channel
+type RedisBroadcaster struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+func NewRedisBroadcaster(client redis.UniversalClient, channel string) *RedisBroadcaster {
+	if channel == "" {
+		channel = DefaultInvalidationChannel
+	}
+	return &RedisBroadcaster{
+		client:  client,
+		channel: channel,
+	}
+}
+
+func (r *RedisBroadcaster) Publish(ctx context.Context, message InvalidationMessage) error {
+	b, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, r.channel, b).Err()
+}
+
+func (r *RedisBroadcaster) Subscribe(ctx context.Context, handler func(ctx context.Context, message InvalidationMessage)) error {
+	pubsub := r.client.Subscribe(ctx, r.channel)
+	defer pubsub.Close()
+	// wait for redis to confirm the subscription before reading messages
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			var message InvalidationMessage
+			if err := json.Unmarshal([]byte(m.Payload), &message); err != nil {
+				ctxLogger.Warn(ctx, "invalid cache invalidation message", zap.String("payload", m.Payload), zap.Error(err))
+				continue
+			}
+			handler(ctx, message)
+		}
+	}
+}
+
+// messageDedup remembers message ids for a time window
+type messageDedup struct {
+	mutex     sync.Mutex
+	window    time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newMessageDedup(window time.Duration) *messageDedup {
+	return &messageDedup{
+		window: window,
+		seen:   map[string]time.Time{},
+	}
+}
+
+// add returns false if the id was already added within the window
+func (d *messageDedup) add(id string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	now := time.Now()
+	if seen, found := d.seen[id]; found && now.Sub(seen) < d.window {
+		return false
+	}
+	if now.Sub(d.lastPrune) >= d.window {
+		for k, seen := range d.seen {
+			if now.Sub(seen) >= d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastPrune = now
+	}
+	d.seen[id] = now
+	return true
+}
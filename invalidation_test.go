@@ -0,0 +1,62 @@
+package QueryHelper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedisBroadcaster(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	broadcaster := NewRedisBroadcaster(client, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan InvalidationMessage, 4)
+	go func() {
+		_ = broadcaster.Subscribe(ctx, func(ctx context.Context, message InvalidationMessage) {
+			received <- message
+		})
+	}()
+
+	message := InvalidationMessage{ID: "1", Origin: "other", Group: "test.resource"}
+	deadline := time.After(5 * time.Second)
+	for {
+		if err := broadcaster.Publish(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-received:
+			if got != message {
+				t.Fatalf("unexpected message %+v", got)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("expected message to be received")
+		}
+	}
+}
+
+func TestMessageDedup(t *testing.T) {
+	dedup := newMessageDedup(time.Minute)
+	if !dedup.add("1") {
+		t.Fatal("expected first message to be applied")
+	}
+	if dedup.add("1") {
+		t.Fatal("expected duplicate message to be ignored")
+	}
+	if !dedup.add("2") {
+		t.Fatal("expected new message to be applied")
+	}
+
+	expired := newMessageDedup(0)
+	if !expired.add("1") || !expired.add("1") {
+		t.Fatal("expected message outside the window to be applied")
+	}
+}
@@ -0,0 +1,139 @@
+package QueryHelper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidJoin = errors.New("invalid join")
+
+// JoinTable is a table that can be joined with JoinOn, tables of every type implement it
+type JoinTable interface {
+	FullTableName() string
+	cacheGroup() string
+}
+
+// JoinOn joins the table when the on expression is true. The alias names the table in the query, columns of the
+// table are referenced through it with Column.Of, and it is required to join the table to itself. Join types are
+// inner, left, right, full and cross, cross joins do not take an on expression. Joins render in the order they
+// are added.
+func (q *Query[T]) JoinOn(table JoinTable, alias string, on Expr, joinType string) *Query[T] {
+	q = q.edit()
+	if err := q.validateJoin(table, alias, on, joinType); err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return q
+	}
+	keyword, _ := joinKeyword(joinType)
+	if !exprSavable(on) {
+		q.cansave = false
+	}
+	q.JoinStmt = append(q.JoinStmt, &JoinStmt{JoinType: keyword, table: table, alias: alias, on: on})
+	return q
+}
+
+func (q *Query[T]) validateJoin(table JoinTable, alias string, on Expr, joinType string) error {
+	if table == nil {
+		return fmt.Errorf("%w: missing table", ErrInvalidJoin)
+	}
+	keyword, err := joinKeyword(joinType)
+	if err != nil {
+		return err
+	}
+	if alias == "" && table.FullTableName() == q.FromTable.FullTableName() {
+		return fmt.Errorf("%w: joining %s to itself requires an alias", ErrInvalidJoin, table.FullTableName())
+	}
+	if alias != "" && !identifier.MatchString(alias) {
+		return fmt.Errorf("%w: invalid alias %q", ErrInvalidJoin, alias)
+	}
+	if strings.EqualFold(alias, q.FromTable.Name) {
+		return fmt.Errorf("%w: alias %q is the name of the table", ErrInvalidJoin, alias)
+	}
+	for _, join := range q.JoinStmt {
+		if alias != "" && strings.EqualFold(join.alias, alias) {
+			return fmt.Errorf("%w: duplicate alias %q", ErrInvalidJoin, alias)
+		}
+		if keyword == "FULL JOIN" && join.JoinType == "FULL JOIN" {
+			return fmt.Errorf("%w: a query can only have one full join", ErrInvalidJoin)
+		}
+	}
+	if keyword == "CROSS JOIN" {
+		if !isNilExpr(on) {
+			return fmt.Errorf("%w: cross joins do not take an on expression", ErrInvalidJoin)
+		}
+		return nil
+	}
+	if isNilExpr(on) {
+		return fmt.Errorf("%w: %s requires an on expression", ErrInvalidJoin, strings.ToLower(keyword))
+	}
+	_, err = on.render(newExprParams(nil))
+	return err
+}
+
+func joinKeyword(joinType string) (string, error) {
+	switch strings.Join(strings.Fields(strings.ToLower(joinType)), " ") {
+	case "", "inner":
+		return "JOIN", nil
+	case "left", "left outer":
+		return "LEFT JOIN", nil
+	case "right", "right outer":
+		return "RIGHT JOIN", nil
+	case "full", "full outer":
+		return "FULL JOIN", nil
+	case "cross":
+		return "CROSS JOIN", nil
+	}
+	return "", fmt.Errorf("%w: unknown join type %q", ErrInvalidJoin, joinType)
+}
+
+// renderJoin returns the join clause and the values of its parameters, the parameters of the on expression are
+// prefixed with the position of the join so they can not collide with the where clause
+func (q *Query[T]) renderJoin(index int, join *JoinStmt) (string, map[string]interface{}) {
+	keyword := join.JoinType
+	if keyword == "FULL JOIN" {
+		keyword = "FULL OUTER JOIN"
+		if q.fullJoinAs != "" {
+			keyword = q.fullJoinAs
+		}
+	}
+	clause := fmt.Sprintf("%s %s", keyword, join.table.FullTableName())
+	if join.alias != "" {
+		clause = fmt.Sprintf("%s AS %s", clause, join.alias)
+	}
+	if isNilExpr(join.on) {
+		return clause, map[string]interface{}{}
+	}
+	params := newExprParams(nil)
+	// on expressions are checked when they are added
+	on, _ := join.on.render(params)
+	on, args := prefixParams(on, params.args, fmt.Sprintf("join_%d_", index))
+	return fmt.Sprintf("%s ON %s", clause, on), args
+}
+
+func (q *Query[T]) hasFullJoin() bool {
+	for _, join := range q.JoinStmt {
+		if join.table != nil && join.JoinType == "FULL JOIN" {
+			return true
+		}
+	}
+	return false
+}
+
+// fullJoinStatement emulates the full join, which mysql does not support, as the rows of the left join and the rows
+// of the right join without a row of the table. Primary key columns are not null, so a null key marks the rows of
+// the right join that the left join does not return, and rows that are equal in every selected column are kept.
+func (q *Query[T]) fullJoinStatement() string {
+	primary := q.FromTable.GetPrimary()
+	sort.Slice(primary, func(i, j int) bool {
+		return primary[i].ColumnOrder < primary[j].ColumnOrder
+	})
+	left, right := q.Clone(), q.Clone()
+	left.fullJoinAs = "LEFT JOIN"
+	right.fullJoinAs = "RIGHT JOIN"
+	// NewTable requires a primary key
+	right = right.WhereExpr(IsNull(primary[0]))
+	return fmt.Sprintf("(%s)\nUNION ALL\n(%s)", left.selectStatement(), right.selectStatement())
+}
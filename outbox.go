@@ -0,0 +1,283 @@
+package QueryHelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Seann-Moser/go-serve/pkg/ctxLogger"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+const (
+	OutboxTableName = "outbox"
+
+	defaultOutboxBatchSize    = 100
+	defaultOutboxPollInterval = time.Second
+	defaultOutboxMaxAttempts  = 10
+)
+
+var ErrNoPublisher = errors.New("outbox relay has no publisher")
+
+// OutboxEvent is a change written to the outbox table in the same transaction as the change itself.
+// AggregateKey is <table>:<row key>, events sharing a key are published in the order they were written.
+type OutboxEvent struct {
+	ID                 int64   `json:"id" db:"id" qc:"primary;auto_generate_id;order;order_asc"`
+	AggregateKey       string  `json:"aggregate_key" db:"aggregate_key" qc:"data_type::varchar(512)"`
+	TableName          string  `json:"table_name" db:"table_name" qc:"data_type::varchar(256)"`
+	Operation          string  `json:"operation" db:"operation" qc:"data_type::varchar(16)"`
+	Actor              string  `json:"actor" db:"actor" qc:"data_type::varchar(256)"`
+	Payload            string  `json:"payload" db:"payload" qc:"data_type::json"`
+	Attempts           int     `json:"attempts" db:"attempts" qc:"default::0"`
+	LastError          string  `json:"last_error" db:"last_error" qc:"data_type::text"`
+	Delivered          bool    `json:"delivered" db:"delivered" qc:"default::false"`
+	CreatedTimestamp   string  `json:"created_timestamp" db:"created_timestamp" qc:"skip;default::created_timestamp"`
+	DeliveredTimestamp *string `json:"delivered_timestamp" db:"delivered_timestamp" qc:"skip"`
+}
+
+func (e *OutboxEvent) Unmarshal(v interface{}) error {
+	return json.Unmarshal([]byte(e.Payload), v)
+}
+
+// Publisher delivers outbox events to a broker, returning an error leaves the event in the outbox to be retried
+type Publisher interface {
+	Publish(ctx context.Context, event *OutboxEvent) error
+}
+
+type PublisherFunc func(ctx context.Context, event *OutboxEvent) error
+
+func (f PublisherFunc) Publish(ctx context.Context, event *OutboxEvent) error {
+	return f(ctx, event)
+}
+
+// EnableOutbox turns on the outbox for the table, it must be called before InitializeTable.
+// Events are only written by InsertTx, UpdateTx and DeleteTx, since they share the callers transaction.
+func (t *Table[T]) EnableOutbox() *Table[T] {
+	t.options.Outbox = true
+	return t
+}
+
+func (t *Table[T]) initializeOutbox(ctx context.Context, db DB) error {
+	if !t.options.Outbox {
+		return nil
+	}
+	outboxTable, err := newInternalTable[OutboxEvent](ctx, db, t.Dataset, OutboxTableName, t.QueryType)
+	if err != nil {
+		return fmt.Errorf("failed creating outbox table: %w", err)
+	}
+	t.outboxTable = outboxTable
+	return nil
+}
+
+// appendOutbox writes an event for every row using the transaction of the change
+func (t *Table[T]) appendOutbox(ctx context.Context, tx *sqlx.Tx, operation Operation, rows ...T) error {
+	if !t.options.Outbox || t.outboxTable == nil || len(rows) == 0 {
+		return nil
+	}
+	events, err := t.outboxEvents(ctx, operation, rows...)
+	if err != nil {
+		return err
+	}
+	args, err := combineStructsWithPrefix[OutboxEvent](events...)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.NamedExecContext(ctx, t.outboxTable.InsertStatement(len(events)), args); err != nil {
+		return fmt.Errorf("failed writing outbox events for %s: %w", t.FullTableName(), err)
+	}
+	return nil
+}
+
+func (t *Table[T]) outboxEvents(ctx context.Context, operation Operation, rows ...T) ([]OutboxEvent, error) {
+	actor, _ := GetActor(ctx)
+	events := make([]OutboxEvent, 0, len(rows))
+	for i := range rows {
+		b, err := json.Marshal(rows[i])
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, OutboxEvent{
+			AggregateKey: t.Name + HistoryKeySeparator + t.RowKey(&rows[i]),
+			TableName:    t.Name,
+			Operation:    string(operation),
+			Actor:        actor,
+			Payload:      string(b),
+		})
+	}
+	return events, nil
+}
+
+// OutboxRelay polls an outbox table and hands undelivered events to a Publisher. Several relays can run
+// against the same table, rows are claimed with SELECT ... FOR UPDATE SKIP LOCKED.
+type OutboxRelay struct {
+	db        *sqlx.DB
+	table     string
+	publisher Publisher
+
+	BatchSize    int
+	PollInterval time.Duration
+	// MaxAttempts stops retrying an event after it failed this many times, later events with the same
+	// aggregate key are held back until it is delivered or removed
+	MaxAttempts int
+}
+
+// NewOutboxRelay creates a relay for the outbox table in dataset, use db.GetDataset to apply the table prefix
+func NewOutboxRelay(db *sqlx.DB, dataset string, publisher Publisher) *OutboxRelay {
+	return &OutboxRelay{
+		db:           db,
+		table:        fmt.Sprintf("%s.%s", dataset, OutboxTableName),
+		publisher:    publisher,
+		BatchSize:    defaultOutboxBatchSize,
+		PollInterval: defaultOutboxPollInterval,
+		MaxAttempts:  defaultOutboxMaxAttempts,
+	}
+}
+
+// Run relays events until the context is cancelled
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			published, err := r.RelayOnce(ctx)
+			if err != nil {
+				ctxLogger.Warn(ctx, "failed relaying outbox events", zap.String("table", r.table), zap.Error(err))
+			}
+			if err != nil || published < r.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce claims a batch of events, publishes them and marks the published events as delivered.
+// It returns the number of events that were delivered.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	if r.publisher == nil {
+		return 0, ErrNoPublisher
+	}
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var batch []*OutboxEvent
+	err = tx.SelectContext(ctx, &batch, tx.Rebind(fmt.Sprintf(
+		"SELECT * FROM %s WHERE delivered = false AND attempts < ? ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED", r.table)),
+		r.MaxAttempts, r.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+	pending, err := r.pendingIDs(ctx, tx, batch)
+	if err != nil {
+		return 0, err
+	}
+
+	var delivered []int64
+	for _, events := range orderedOutboxEvents(batch, pending) {
+		for _, event := range events {
+			if err := r.publisher.Publish(ctx, event); err != nil {
+				_, updateErr := tx.ExecContext(ctx, tx.Rebind(fmt.Sprintf(
+					"UPDATE %s SET attempts = attempts + 1, last_error = ? WHERE id = ?", r.table)), err.Error(), event.ID)
+				if updateErr != nil {
+					return 0, updateErr
+				}
+				break
+			}
+			delivered = append(delivered, event.ID)
+		}
+	}
+	if len(delivered) > 0 {
+		query, args, err := sqlx.In(fmt.Sprintf(
+			"UPDATE %s SET delivered = true, delivered_timestamp = CURRENT_TIMESTAMP WHERE id IN (?)", r.table), delivered)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, tx.Rebind(query), args...); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing outbox relay: %w", err)
+	}
+	return len(delivered), nil
+}
+
+// pendingIDs returns every undelivered event id, in order, for the aggregate keys in the batch. It includes
+// events locked by other relays, which is what keeps ordering intact when relays run concurrently.
+func (r *OutboxRelay) pendingIDs(ctx context.Context, tx *sqlx.Tx, batch []*OutboxEvent) (map[string][]int64, error) {
+	var keys []string
+	seen := map[string]bool{}
+	for _, e := range batch {
+		if !seen[e.AggregateKey] {
+			seen[e.AggregateKey] = true
+			keys = append(keys, e.AggregateKey)
+		}
+	}
+	query, args, err := sqlx.In(fmt.Sprintf(
+		"SELECT id, aggregate_key FROM %s WHERE delivered = false AND aggregate_key IN (?) ORDER BY id", r.table), keys)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID           int64  `db:"id"`
+		AggregateKey string `db:"aggregate_key"`
+	}
+	if err := tx.SelectContext(ctx, &rows, tx.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	pending := map[string][]int64{}
+	for _, row := range rows {
+		pending[row.AggregateKey] = append(pending[row.AggregateKey], row.ID)
+	}
+	return pending, nil
+}
+
+// orderedOutboxEvents groups the batch by aggregate key. Each group only holds the leading run of
+// pending events that were claimed by this batch, an event is never published before an earlier
+// event for the same key that is still undelivered.
+func orderedOutboxEvents(batch []*OutboxEvent, pending map[string][]int64) [][]*OutboxEvent {
+	claimed := map[int64]*OutboxEvent{}
+	claimedKeys := map[string][]int64{}
+	var keys []string
+	for _, e := range batch {
+		if _, found := claimedKeys[e.AggregateKey]; !found {
+			keys = append(keys, e.AggregateKey)
+		}
+		claimedKeys[e.AggregateKey] = append(claimedKeys[e.AggregateKey], e.ID)
+		claimed[e.ID] = e
+	}
+	var ordered [][]*OutboxEvent
+	for _, key := range keys {
+		ids, found := pending[key]
+		if !found {
+			ids = claimedKeys[key]
+		}
+		var events []*OutboxEvent
+		for _, id := range ids {
+			e, found := claimed[id]
+			if !found {
+				break
+			}
+			events = append(events, e)
+		}
+		if len(events) > 0 {
+			ordered = append(ordered, events)
+		}
+	}
+	return ordered
+}
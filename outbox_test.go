@@ -0,0 +1,106 @@
+package QueryHelper
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestOrderedOutboxEvents(t *testing.T) {
+	batch := []*OutboxEvent{
+		{ID: 1, AggregateKey: "doc:1"},
+		{ID: 2, AggregateKey: "doc:2"},
+		{ID: 4, AggregateKey: "doc:1"},
+		{ID: 5, AggregateKey: "doc:3"},
+		{ID: 6, AggregateKey: "doc:3"},
+	}
+	pending := map[string][]int64{
+		"doc:1": {1, 3, 4}, // 3 is claimed by another relay
+		"doc:2": {2},
+		"doc:3": {0, 5, 6}, // 0 is held back after failing
+	}
+	ordered := orderedOutboxEvents(batch, pending)
+	if len(ordered) != 2 {
+		t.Fatalf("expected 2 aggregates to be publishable, got %d", len(ordered))
+	}
+	if len(ordered[0]) != 1 || ordered[0][0].ID != 1 {
+		t.Fatalf("expected only event 1 for doc:1, got %v", ordered[0])
+	}
+	if len(ordered[1]) != 1 || ordered[1][0].ID != 2 {
+		t.Fatalf("expected event 2 for doc:2, got %v", ordered[1])
+	}
+}
+
+func TestTable_OutboxEvents(t *testing.T) {
+	ctx := WithActor(context.Background(), "user-1")
+	documentTable, err := NewTable[VersionedDocument]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := documentTable.EnableOutbox().InitializeTable(ctx, NewMockDB()); err != nil {
+		t.Fatal(err)
+	}
+	if documentTable.outboxTable == nil || documentTable.outboxTable.FullTableName() != "test.outbox" {
+		t.Fatal("expected outbox table to be created")
+	}
+	events, err := documentTable.outboxEvents(ctx, OperationUpdate, VersionedDocument{ID: "1", Title: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].AggregateKey != "versioned_document:1" || events[0].Actor != "user-1" {
+		t.Fatalf("unexpected events %v", events)
+	}
+	var document VersionedDocument
+	if err := events[0].Unmarshal(&document); err != nil || document.Title != "new" {
+		t.Fatalf("unexpected payload %s: %v", events[0].Payload, err)
+	}
+}
+
+func TestOutboxRelay_RelayOnce(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockDB.Close()
+	// postgres bind vars make sure every statement is rebound for the driver
+	db := sqlx.NewDb(mockDB, "postgres")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM test\.outbox WHERE delivered = false AND attempts < \$1 ORDER BY id LIMIT \$2 FOR UPDATE SKIP LOCKED`).
+		WithArgs(defaultOutboxMaxAttempts, defaultOutboxBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "aggregate_key", "payload"}).
+			AddRow(1, "doc:1", "{}").
+			AddRow(2, "doc:2", "{}"))
+	mock.ExpectQuery(`SELECT id, aggregate_key FROM test\.outbox WHERE delivered = false AND aggregate_key IN \(\$1, \$2\) ORDER BY id`).
+		WithArgs("doc:1", "doc:2").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "aggregate_key"}).AddRow(1, "doc:1").AddRow(2, "doc:2"))
+	mock.ExpectExec(`UPDATE test\.outbox SET attempts = attempts \+ 1, last_error = \$1 WHERE id = \$2`).
+		WithArgs("broker unavailable", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE test\.outbox SET delivered = true, delivered_timestamp = CURRENT_TIMESTAMP WHERE id IN \(\$1\)`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	var published []int64
+	relay := NewOutboxRelay(db, "test", PublisherFunc(func(ctx context.Context, event *OutboxEvent) error {
+		if event.ID == 2 {
+			return errors.New("broker unavailable")
+		}
+		published = append(published, event.ID)
+		return nil
+	}))
+	delivered, err := relay.RelayOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered != 1 || len(published) != 1 || published[0] != 1 {
+		t.Fatalf("expected only event 1 to be delivered, got %d %v", delivered, published)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
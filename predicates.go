@@ -0,0 +1,189 @@
+package QueryHelper
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// likeEscape escapes the wildcards of patterns built by StartsWith, EndsWith and Contains
+const likeEscape = '!'
+
+var namedParam = regexp.MustCompile(`:(\w+)`)
+
+// Range holds the bounds of a between condition, both bounds are inclusive
+type Range struct {
+	From interface{}
+	To   interface{}
+}
+
+// Subquery is a query that can be used in the where clause or select list of another query
+type Subquery interface {
+	// subquery returns the built sql of the query and the values of its parameters
+	subquery() (string, map[string]interface{}, error)
+	subqueryTags() []string
+}
+
+func (q *Query[T]) subquery() (string, map[string]interface{}, error) {
+	if q == nil {
+		return "", nil, fmt.Errorf("%w: nil subquery", ErrInvalidCondition)
+	}
+	if q.err != nil {
+		return "", nil, q.err
+	}
+	built := q.Clone().Build()
+	if built.err != nil {
+		return "", nil, built.err
+	}
+	query := strings.TrimSuffix(strings.TrimSpace(built.Query), ";")
+	return query, built.Args(), nil
+}
+
+func (q *Query[T]) subqueryTags() []string {
+	if q == nil {
+		return nil
+	}
+	return append([]string{q.FromTable.FullTableName() + q.tmpPrefix}, q.cacheTags()...)
+}
+
+// renamedSubquery prefixes the parameters of the subquery so they can not collide with the parameters of the outer query
+func renamedSubquery(sub Subquery, prefix string) (string, map[string]interface{}, error) {
+	if sub == nil {
+		return "", nil, fmt.Errorf("%w: nil subquery", ErrInvalidCondition)
+	}
+	query, args, err := sub.subquery()
+	if err != nil {
+		return "", nil, err
+	}
+	query, renamed := prefixParams(query, args, prefix)
+	return query, renamed, nil
+}
+
+// prefixParams prefixes the parameters of the query that have a value in args
+func prefixParams(query string, args map[string]interface{}, prefix string) (string, map[string]interface{}) {
+	renamed := make(map[string]interface{}, len(args))
+	for k, v := range args {
+		renamed[prefix+k] = v
+	}
+	query = namedParam.ReplaceAllStringFunc(query, func(match string) string {
+		if _, found := args[match[1:]]; found {
+			return ":" + prefix + match[1:]
+		}
+		return match
+	})
+	return query, renamed
+}
+
+// EscapeLike escapes the like wildcards in s, the result matches s literally in conditions built with an escaped pattern
+func EscapeLike(s string) string {
+	escape := string(likeEscape)
+	return strings.NewReplacer(escape, escape+escape, "%", escape+"%", "_", escape+"_").Replace(s)
+}
+
+func Between(column Column, from, to interface{}) *WhereStmt {
+	return Cond(column, "between", Range{From: from, To: to})
+}
+
+func NotBetween(column Column, from, to interface{}) *WhereStmt {
+	return Cond(column, "not between", Range{From: from, To: to})
+}
+
+// Like matches the pattern as is, % and _ are wildcards
+func Like(column Column, pattern string) *WhereStmt {
+	return Cond(column, "like", pattern)
+}
+
+// ILike is a case insensitive Like
+func ILike(column Column, pattern string) *WhereStmt {
+	return Cond(column, "ilike", pattern)
+}
+
+// StartsWith matches values beginning with prefix, wildcards in prefix are matched literally
+func StartsWith(column Column, prefix string) *WhereStmt {
+	return escapedLike(column, EscapeLike(prefix)+"%")
+}
+
+// EndsWith matches values ending with suffix, wildcards in suffix are matched literally
+func EndsWith(column Column, suffix string) *WhereStmt {
+	return escapedLike(column, "%"+EscapeLike(suffix))
+}
+
+// Contains matches values containing s, wildcards in s are matched literally
+func Contains(column Column, s string) *WhereStmt {
+	return escapedLike(column, "%"+EscapeLike(s)+"%")
+}
+
+func escapedLike(column Column, pattern string) *WhereStmt {
+	w := Cond(column, "like", pattern)
+	w.escaped = true
+	return w
+}
+
+func IsNull(column Column) *WhereStmt {
+	return Cond(column, "is null", nil)
+}
+
+func IsNotNull(column Column) *WhereStmt {
+	return Cond(column, "is not null", nil)
+}
+
+// IsDistinctFrom is a null safe not equal, null is distinct from every value but null
+func IsDistinctFrom(column Column, value interface{}) *WhereStmt {
+	return Cond(column, "is distinct from", value)
+}
+
+func IsNotDistinctFrom(column Column, value interface{}) *WhereStmt {
+	return Cond(column, "is not distinct from", value)
+}
+
+// Exists is true when the subquery returns a row, its parameters are renamed so they do not collide with the outer query
+func Exists(sub Subquery) *WhereStmt {
+	return &WhereStmt{Conditional: "exists", RightValue: sub, JoinOperator: "AND"}
+}
+
+func NotExists(sub Subquery) *WhereStmt {
+	return &WhereStmt{Conditional: "not exists", RightValue: sub, JoinOperator: "AND"}
+}
+
+type selectSubquery struct {
+	sub   Subquery
+	alias string
+}
+
+// SelectSubquery adds a scalar subquery to the select list as alias, the subquery must return a single value
+func (q *Query[T]) SelectSubquery(sub Subquery, alias string) *Query[T] {
+	q = q.edit()
+	if alias == "" {
+		if q.err == nil {
+			q.err = fmt.Errorf("%w: select subquery requires an alias", ErrInvalidCondition)
+		}
+		return q
+	}
+	if _, _, err := renamedSubquery(sub, ""); err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return q
+	}
+	q.cansave = false
+	q.selectSubqueries = append(q.selectSubqueries, selectSubquery{sub: sub, alias: alias})
+	return q
+}
+
+// renderSelectSubqueries returns the select list entries of the scalar subqueries and the values of their parameters
+func (q *Query[T]) renderSelectSubqueries() ([]string, map[string]interface{}) {
+	var columns []string
+	args := map[string]interface{}{}
+	for _, s := range q.selectSubqueries {
+		// subqueries are checked when they are added
+		query, subArgs, err := renamedSubquery(s.sub, "select_"+s.alias+"_")
+		if err != nil {
+			continue
+		}
+		columns = append(columns, fmt.Sprintf("(%s) AS %s", strings.ReplaceAll(query, "\n", "\n\t"), s.alias))
+		for k, v := range subArgs {
+			args[k] = v
+		}
+	}
+	return columns, args
+}
@@ -0,0 +1,182 @@
+package QueryHelper
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const DefaultStatementCacheSize = 256
+
+// PreparedDB is implemented by databases that keep server side prepared statements for named queries
+type PreparedDB interface {
+	PreparedQueryContext(ctx context.Context, name, query string, options *DBOptions, args interface{}) (DBRow, error)
+}
+
+// preparedQueries holds the built sql of named queries so they are only built once per dialect
+var preparedQueries = NewStatementCache(DefaultStatementCacheSize)
+
+// InvalidatePreparedQueries drops every saved named query, they are rebuilt on their next use
+func InvalidatePreparedQueries() {
+	preparedQueries.Invalidate()
+}
+
+type statementKey struct {
+	name    string
+	dialect string
+}
+
+type statementEntry struct {
+	key     statementKey
+	query   string
+	stmt    *sqlx.NamedStmt
+	users   int
+	evicted bool
+}
+
+// StatementCache is a concurrency safe LRU of named queries keyed by query name and dialect. Entries can hold a
+// prepared statement, which is closed once it is evicted and no longer in use.
+type StatementCache struct {
+	mutex    sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[statementKey]*list.Element
+}
+
+func NewStatementCache(capacity int) *StatementCache {
+	if capacity <= 0 {
+		capacity = DefaultStatementCacheSize
+	}
+	return &StatementCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  map[statementKey]*list.Element{},
+	}
+}
+
+// Query returns the sql saved for the name and dialect
+func (c *StatementCache) Query(name, dialect string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, found := c.entries[statementKey{name: name, dialect: dialect}]
+	if !found {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*statementEntry).query, true
+}
+
+// SetQuery saves the sql for the name and dialect, a prepared statement for different sql is dropped
+func (c *StatementCache) SetQuery(name, dialect, query string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := statementKey{name: name, dialect: dialect}
+	if element, found := c.entries[key]; found {
+		if element.Value.(*statementEntry).query == query {
+			c.order.MoveToFront(element)
+			return
+		}
+		c.remove(element)
+	}
+	c.add(&statementEntry{key: key, query: query})
+}
+
+// Prepare returns the prepared statement for the name and dialect, preparing it on db when it is missing or was
+// prepared for different sql. The returned release function must be called once the statement is no longer used.
+func (c *StatementCache) Prepare(ctx context.Context, db *sqlx.DB, name, dialect, query string) (*sqlx.NamedStmt, func(), error) {
+	key := statementKey{name: name, dialect: dialect}
+	if entry := c.acquire(key, query); entry != nil {
+		return entry.stmt, c.releaser(entry), nil
+	}
+	stmt, err := db.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, found := c.entries[key]; found {
+		existing := element.Value.(*statementEntry)
+		if existing.query == query && existing.stmt != nil {
+			// another caller prepared the same statement first
+			_ = stmt.Close()
+			existing.users++
+			c.order.MoveToFront(element)
+			return existing.stmt, c.releaser(existing), nil
+		}
+		c.remove(element)
+	}
+	entry := &statementEntry{key: key, query: query, stmt: stmt, users: 1}
+	c.add(entry)
+	return stmt, c.releaser(entry), nil
+}
+
+// Invalidate drops every entry, used when the schema changes
+func (c *StatementCache) Invalidate() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, element := range c.entries {
+		c.remove(element)
+	}
+}
+
+func (c *StatementCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+func (c *StatementCache) acquire(key statementKey, query string) *statementEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, found := c.entries[key]
+	if !found {
+		return nil
+	}
+	entry := element.Value.(*statementEntry)
+	if entry.query != query || entry.stmt == nil {
+		return nil
+	}
+	entry.users++
+	c.order.MoveToFront(element)
+	return entry
+}
+
+func (c *StatementCache) releaser(entry *statementEntry) func() {
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			entry.users--
+			c.closeUnused(entry)
+		})
+	}
+}
+
+func (c *StatementCache) add(entry *statementEntry) {
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *StatementCache) remove(element *list.Element) {
+	entry := element.Value.(*statementEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+	entry.evicted = true
+	c.closeUnused(entry)
+}
+
+func (c *StatementCache) closeUnused(entry *statementEntry) {
+	if entry.evicted && entry.users <= 0 && entry.stmt != nil {
+		_ = entry.stmt.Close()
+		entry.stmt = nil
+	}
+}
@@ -0,0 +1,251 @@
+package QueryHelper
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// countingConnector is a driver that counts prepared and closed statements and returns no rows
+type countingConnector struct {
+	prepared int32
+	closed   int32
+}
+
+func (c *countingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &countingConn{connector: c}, nil
+}
+
+func (c *countingConnector) Driver() driver.Driver {
+	return nil
+}
+
+type countingConn struct {
+	connector *countingConnector
+}
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	atomic.AddInt32(&c.connector.prepared, 1)
+	return &countingStmt{connector: c.connector}, nil
+}
+
+func (c *countingConn) Close() error {
+	return nil
+}
+
+func (c *countingConn) Begin() (driver.Tx, error) {
+	return countingTx{}, nil
+}
+
+type countingTx struct{}
+
+func (countingTx) Commit() error   { return nil }
+func (countingTx) Rollback() error { return nil }
+
+type countingStmt struct {
+	connector *countingConnector
+}
+
+func (s *countingStmt) Close() error {
+	atomic.AddInt32(&s.connector.closed, 1)
+	return nil
+}
+
+func (s *countingStmt) NumInput() int {
+	return -1
+}
+
+func (s *countingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (s *countingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return nil }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func newCountingDB() (*sqlx.DB, *countingConnector) {
+	connector := &countingConnector{}
+	db := sqlx.NewDb(sql.OpenDB(connector), "mysql")
+	db.SetMaxOpenConns(1)
+	return db, connector
+}
+
+func TestStatementCache_LRU(t *testing.T) {
+	cache := NewStatementCache(2)
+	cache.SetQuery("a", QueryTypeSQL, "SELECT a")
+	cache.SetQuery("b", QueryTypeSQL, "SELECT b")
+	if _, found := cache.Query("a", QueryTypeSQL); !found {
+		t.Fatal("expected a to be cached")
+	}
+	cache.SetQuery("c", QueryTypeSQL, "SELECT c")
+	if _, found := cache.Query("b", QueryTypeSQL); found {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+	if _, found := cache.Query("a", QueryTypeFireBase); found {
+		t.Fatal("expected entries to be keyed by dialect")
+	}
+	if cache.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", cache.Len())
+	}
+	cache.Invalidate()
+	if cache.Len() != 0 {
+		t.Fatalf("expected no entries after invalidation, got %d", cache.Len())
+	}
+}
+
+func TestStatementCache_Prepare(t *testing.T) {
+	ctx := context.Background()
+	db, connector := newCountingDB()
+	defer db.Close()
+	cache := NewStatementCache(1)
+
+	_, release, err := cache.Prepare(ctx, db, "by-id", QueryTypeSQL, "SELECT id FROM t WHERE id = :id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	_, release, err = cache.Prepare(ctx, db, "by-id", QueryTypeSQL, "SELECT id FROM t WHERE id = :id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connector.prepared != 1 {
+		t.Fatalf("expected statement to be reused, prepared %d times", connector.prepared)
+	}
+
+	// evicting a statement in use only closes it once released
+	_, releaseOther, err := cache.Prepare(ctx, db, "by-name", QueryTypeSQL, "SELECT id FROM t WHERE name = :name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connector.closed != 0 {
+		t.Fatalf("expected statement in use to stay open, closed %d", connector.closed)
+	}
+	release()
+	if connector.closed != 1 {
+		t.Fatalf("expected evicted statement to be closed once released, closed %d", connector.closed)
+	}
+	releaseOther()
+	cache.Invalidate()
+	if connector.closed != 2 {
+		t.Fatalf("expected invalidation to close statements, closed %d", connector.closed)
+	}
+}
+
+func TestSqlDB_PreparedQueryContext(t *testing.T) {
+	ctx := context.Background()
+	db, connector := newCountingDB()
+	defer db.Close()
+	s := NewSql(db)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rows, err := s.PreparedQueryContext(ctx, "by-id", "SELECT id FROM t WHERE id = :id", nil, map[string]interface{}{"id": 1})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = rows.Close()
+		}()
+	}
+	wg.Wait()
+	if prepared := atomic.LoadInt32(&connector.prepared); prepared > 2 {
+		t.Fatalf("expected the named statement to be prepared once, prepared %d times", prepared)
+	}
+	if s.statements.Len() != 1 {
+		t.Fatalf("expected a single cached statement, got %d", s.statements.Len())
+	}
+
+	if err := s.CreateTable(ctx, "test", "t", map[string]Column{"id": {Name: "id", Type: "int", Primary: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if s.statements.Len() != 0 {
+		t.Fatal("expected schema changes to invalidate prepared statements")
+	}
+}
+
+func TestQuery_SavedNamedQuery(t *testing.T) {
+	table, err := NewTable[AccountUserRole]("prepared", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := func(userID string) *Query[AccountUserRole] {
+		q := QueryTable[AccountUserRole](table).SetName("prepared-roles-for-user")
+		return q.Where(q.Column("user_id"), "=", "AND", 0, userID).Build()
+	}
+	first := build("1")
+	saved, found := preparedQueries.Query(first.statementName(), QueryTypeSQL)
+	if !found || saved != first.Query {
+		t.Fatal("expected the named query to be saved")
+	}
+	preparedQueries.SetQuery(first.statementName(), QueryTypeSQL, saved+"\n-- saved")
+	second := build("2")
+	if second.Query != saved+"\n-- saved" {
+		t.Fatal("expected the saved named query to be reused without building")
+	}
+	if second.Args()["user_id"] != "2" {
+		t.Fatal("expected saved named queries to keep their arguments")
+	}
+	if first.GetCacheKey() == second.GetCacheKey() {
+		t.Fatal("expected named queries with different arguments to have different cache keys")
+	}
+	InvalidatePreparedQueries()
+	if third := build("3"); third.Query == second.Query {
+		t.Fatal("expected invalidated named query to be rebuilt")
+	}
+}
+
+func TestQuery_RunPreparesOnce(t *testing.T) {
+	ctx := context.Background()
+	db, connector := newCountingDB()
+	defer db.Close()
+	s := NewSql(db)
+	table, err := NewTable[AccountUserRole]("prepared_run", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		q := QueryTable[AccountUserRole](table).SetName("prepared-run-roles")
+		if _, err := q.Where(q.Column("user_id"), "=", "AND", 0, fmt.Sprint(i)).Run(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if prepared := atomic.LoadInt32(&connector.prepared); prepared != 1 {
+		t.Fatalf("expected the named query to be prepared once, prepared %d times", prepared)
+	}
+}
+
+func TestQuery_StatementNameIncludesTable(t *testing.T) {
+	table, err := NewTable[AccountUserRole]("prepared", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewTable[AccountUserRole]("prepared_other", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := QueryTable[AccountUserRole](table).SetName("prepared-shared-name").Build()
+	second := QueryTable[AccountUserRole](other).SetName("prepared-shared-name").Build()
+	if first.statementName() == second.statementName() {
+		t.Fatal("expected queries on different tables to be saved apart")
+	}
+	if !strings.Contains(second.Query, "prepared_other.account_user_role") {
+		t.Fatalf("expected the sql of the other table, got %s", second.Query)
+	}
+}
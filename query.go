@@ -9,7 +9,6 @@ import (
 	"github.com/Seann-Moser/go-serve/pkg/ctxLogger"
 	"go.uber.org/zap"
 	"reflect"
-	"strconv"
 	"strings"
 	"time"
 
@@ -17,8 +16,6 @@ import (
 	"go.opentelemetry.io/otel"
 )
 
-var QueryPrepare = map[string]string{}
-
 type Query[T any] struct {
 	Name                  string
 	err                   error
@@ -28,6 +25,8 @@ type Query[T any] struct {
 	FromQuery             *Query[T]
 	JoinStmt              []*JoinStmt
 	WhereStmts            []*WhereStmt
+	WhereExprs            []Expr
+	HavingStmts           []*WhereStmt
 	GroupByStmt           []Column
 	OrderByStmt           []Column
 	MapKeyColumns         []Column
@@ -51,11 +50,26 @@ type Query[T any] struct {
 	}
 
 	tmpPrefix string
+
+	staleDuration         time.Duration
+	negativeCacheDuration time.Duration
+	immutable             bool
+	selectSubqueries      []selectSubquery
+	distinct              bool
+	setOperations         []setOperation
+	ctes                  []commonTableExpression
+	fromWith              string
+	fullJoinAs            string
 }
 
 type JoinStmt struct {
 	Columns  map[string]Column
 	JoinType string
+
+	// table, alias and on are set for joins added with JoinOn
+	table JoinTable
+	alias string
+	on    Expr
 }
 
 func GetQuery[T any](ctx context.Context) *Query[T] {
@@ -73,20 +87,21 @@ func generateGroupBy(groupBy []Column) string {
 		if c.Name == "" {
 			return ""
 		}
-		if c.SelectAs == "" {
-			if c.GroupByName != "" {
-				columns = append(columns, c.GroupByName)
-			} else {
-				columns = append(columns, c.Name)
-			}
-		} else {
-			columns = append(columns, c.SelectAs)
-		}
-
+		columns = append(columns, groupByName(c))
 	}
 	return "GROUP BY " + strings.Join(columns, ",")
 }
 
+func groupByName(c Column) string {
+	if c.SelectAs != "" {
+		return c.SelectAs
+	}
+	if c.GroupByName != "" {
+		return c.GroupByName
+	}
+	return c.Name
+}
+
 func QueryTable[T any](table *Table[T]) *Query[T] {
 	return &Query[T]{
 		Name:                  "",
@@ -110,56 +125,150 @@ func QueryTable[T any](table *Table[T]) *Query[T] {
 	}
 }
 
+// Clone returns a copy of the query that can be changed without affecting q
+func (q *Query[T]) Clone() *Query[T] {
+	c := *q
+	c.SelectColumns = append([]Column{}, q.SelectColumns...)
+	c.DistinctSelectColumns = append([]Column{}, q.DistinctSelectColumns...)
+	c.GroupByStmt = append([]Column{}, q.GroupByStmt...)
+	c.OrderByStmt = append([]Column{}, q.OrderByStmt...)
+	c.MapKeyColumns = append([]Column{}, q.MapKeyColumns...)
+	c.selectSubqueries = append([]selectSubquery{}, q.selectSubqueries...)
+	c.setOperations = append([]setOperation{}, q.setOperations...)
+	c.ctes = append([]commonTableExpression{}, q.ctes...)
+	c.JoinStmt = make([]*JoinStmt, 0, len(q.JoinStmt))
+	for _, join := range q.JoinStmt {
+		tmp := *join
+		c.JoinStmt = append(c.JoinStmt, &tmp)
+	}
+	c.WhereStmts = make([]*WhereStmt, 0, len(q.WhereStmts))
+	for _, where := range q.WhereStmts {
+		tmp := *where
+		c.WhereStmts = append(c.WhereStmts, &tmp)
+	}
+	c.HavingStmts = make([]*WhereStmt, 0, len(q.HavingStmts))
+	for _, having := range q.HavingStmts {
+		tmp := *having
+		c.HavingStmts = append(c.HavingStmts, &tmp)
+	}
+	// expressions are not changed once built, so they can be shared
+	c.WhereExprs = append([]Expr{}, q.WhereExprs...)
+	c.WhereColumns = make(map[string]int, len(q.WhereColumns))
+	for k, v := range q.WhereColumns {
+		c.WhereColumns[k] = v
+	}
+	if q.FromQuery != nil {
+		c.FromQuery = q.FromQuery.Clone()
+	}
+	return &c
+}
+
+// Immutable returns a copy on write version of the query, every builder method returns a new query and
+// leaves the receiver unchanged, so a base query can be shared and extended from several goroutines
+func (q *Query[T]) Immutable() *Query[T] {
+	c := q.Clone()
+	c.immutable = true
+	return c
+}
+
+// edit returns the query a builder method should change, the built sql is dropped since it no longer matches
+func (q *Query[T]) edit() *Query[T] {
+	if q.immutable {
+		q = q.Clone()
+	}
+	q.Query = ""
+	return q
+}
+
+func (q *Query[T]) missingColumn() *Query[T] {
+	if q.immutable && q.err == nil {
+		q.err = fmt.Errorf("missing column from table(%s)", q.FromTable.FullTableName())
+	}
+	return q
+}
+
 func (q *Query[T]) Select(columns ...Column) *Query[T] {
+	q = q.edit()
 	for _, c := range columns {
 		if c.Name == "" {
 			continue
 		}
+		if c.window != nil && c.window.err != nil {
+			if q.err == nil {
+				q.err = c.window.err
+			}
+			continue
+		}
 		q.SelectColumns = append(q.SelectColumns, c)
 	}
 	return q
 }
 
+// Distinct removes duplicate rows, the columns are added to the select list. Without columns the rows of the
+// current select list are deduplicated.
+func (q *Query[T]) Distinct(columns ...Column) *Query[T] {
+	q = q.edit()
+	q.distinct = true
+	for _, c := range columns {
+		if c.Name == "" {
+			continue
+		}
+		q.DistinctSelectColumns = append(q.DistinctSelectColumns, c)
+	}
+	return q
+}
+
+// SkipCache reads from the db even when the query is set to use the cache
 func (q *Query[T]) SkipCache() *Query[T] {
+	q = q.edit()
 	q.skipCache = true
 	return q
 }
 
 func (q *Query[T]) From(query *Query[T]) *Query[T] {
+	q = q.edit()
+	if query != nil && query.err != nil {
+		if q.err == nil {
+			q.err = query.err
+		}
+		return q
+	}
 	q.FromQuery = query
 	return q
 }
-func (q *Query[T]) hasSaved() bool {
-	if q.Name == "" {
-		return false
-	}
-	_, f := QueryPrepare[q.Name]
-	return f
-}
 
+// canSave is false for queries whose sql depends on the arguments, like in conditions that expand the values
 func (q *Query[T]) canSave() bool {
-	if q.Name == "" && !q.cansave {
-		return false
+	return q.Name != "" && q.cansave
+}
+
+// statementName keys the saved sql and prepared statement of the query, the table name keeps queries with the
+// same name on tables with a different prefix or suffix apart
+func (q *Query[T]) statementName() string {
+	if !q.canSave() {
+		return ""
 	}
-	_, f := QueryPrepare[q.Name]
-	return f
+	return q.Name + "@" + q.FromTable.FullTableName()
+}
+
+func (q *Query[T]) dialect() string {
+	return string(q.FromTable.QueryType)
 }
 
 func (q *Query[T]) Column(name string) Column {
 	if q.err != nil {
 		return Column{}
 	}
-	if q.hasSaved() {
-		return Column{}
-	}
 	c := q.FromTable.GetColumn(name)
-	if c.Name == "" {
+	if c.Name == "" && !q.immutable {
+		// copy on write queries can not be changed here, the where using the column records the error instead
 		q.err = fmt.Errorf("missing column from table(%s) %s", q.FromTable.FullTableName(), name)
 	}
 	return c
 }
 
 func (q *Query[T]) Join(tableColumns map[string]Column, joinType string) *Query[T] {
+	q = q.edit()
 	q.JoinStmt = append(q.JoinStmt, &JoinStmt{
 		Columns:  tableColumns,
 		JoinType: joinType,
@@ -168,6 +277,7 @@ func (q *Query[T]) Join(tableColumns map[string]Column, joinType string) *Query[
 }
 
 func (q *Query[T]) JoinColumn(joinType string, tableColumns Column) *Query[T] {
+	q = q.edit()
 	q.JoinStmt = append(q.JoinStmt, &JoinStmt{
 		Columns:  map[string]Column{tableColumns.Name: tableColumns},
 		JoinType: joinType,
@@ -176,18 +286,21 @@ func (q *Query[T]) JoinColumn(joinType string, tableColumns Column) *Query[T] {
 }
 
 func (q *Query[T]) EnableNoLock() *Query[T] {
+	q = q.edit()
 	q.NoLock = true
 	q.ReadPast = false
 	return q
 }
 
 func (q *Query[T]) EnableReadPast() *Query[T] {
+	q = q.edit()
 	q.NoLock = false
 	q.ReadPast = true
 	return q
 }
 
 func (q *Query[T]) MapColumns(column ...Column) *Query[T] {
+	q = q.edit()
 	if column == nil {
 		return q
 	}
@@ -195,11 +308,12 @@ func (q *Query[T]) MapColumns(column ...Column) *Query[T] {
 	return q
 }
 func (q *Query[T]) UniqueWhere(column Column, conditional, joinOperator string, level int, value interface{}, flip bool) *Query[T] {
+	q = q.edit()
 	if level < 0 {
 		level = 0
 	}
 	if column.Name == "" {
-		return q
+		return q.missingColumn()
 	}
 	if strings.Contains(conditional, "in") {
 		q.cansave = false
@@ -218,63 +332,89 @@ func (q *Query[T]) UniqueWhere(column Column, conditional, joinOperator string,
 		q.WhereColumns[column.FullTableName()]++
 	}
 	stmt.Index = q.WhereColumns[column.FullTableName()]
-	q.WhereStmts = append(q.WhereStmts, stmt)
-	return q
+	return q.addWhere(stmt)
 }
 
+// Where adds a condition on the column. Nested conditions are added by passing an Expr built with And, Or and Not
+// as the value without a column, e.g. Where(Column{}, "", "AND", 0, Or(...)), it is joined with AND like WhereExpr.
 func (q *Query[T]) Where(column Column, conditional, joinOperator string, level int, value interface{}) *Query[T] {
+	if expr, ok := value.(Expr); ok && column.Name == "" {
+		return q.WhereExpr(expr)
+	}
+	q = q.edit()
 	if level < 0 {
 		level = 0
 	}
 	if strings.Contains(conditional, "in") {
 		q.cansave = false
 	}
-	if q.hasSaved() {
-		return q
-	}
 	if column.Name == "" {
-		return q
+		return q.missingColumn()
 	}
 
-	q.WhereStmts = append(q.WhereStmts, &WhereStmt{
+	return q.addWhere(&WhereStmt{
 		LeftValue:    column,
 		Conditional:  conditional,
 		RightValue:   value,
 		Level:        level,
 		JoinOperator: joinOperator,
 	})
-
-	return q
 }
 
 func (q *Query[T]) W(column Column, conditional string, value interface{}) *Query[T] {
+	q = q.edit()
 	if column.Name == "" {
-		return q
-	}
-	if q.hasSaved() {
-		return q
+		return q.missingColumn()
 	}
 	if strings.Contains(conditional, "in") {
 		q.cansave = false
 	}
-	q.WhereStmts = append(q.WhereStmts, &WhereStmt{
+	return q.addWhere(&WhereStmt{
 		LeftValue:    column,
 		Conditional:  conditional,
 		RightValue:   value,
 		Level:        0,
 		JoinOperator: "AND",
 	})
+}
 
+// addWhere appends the statement, statements that can not be rendered fail the query instead of being dropped
+func (q *Query[T]) addWhere(stmt *WhereStmt) *Query[T] {
+	if _, _, err := stmt.bind(stmt.paramName()); err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return q
+	}
+	if _, isSubquery := stmt.RightValue.(Subquery); isSubquery {
+		q.cansave = false
+	}
+	q.appendWhere(stmt)
 	return q
 }
 
+// appendWhere adds the statement, statements without a column are numbered by their position among them
+func (q *Query[T]) appendWhere(stmt *WhereStmt) {
+	if stmt.LeftValue.Name == "" {
+		stmt.Index = 0
+		for _, w := range q.WhereStmts {
+			if w.LeftValue.Name == "" {
+				stmt.Index++
+			}
+		}
+	}
+	q.WhereStmts = append(q.WhereStmts, stmt)
+}
+
 func (q *Query[T]) Page(limit int, offset int) *Query[T] {
+	q = q.edit()
 	q.Pagination.Limit = limit
 	q.Pagination.Offset = offset
 	return q
 }
 
 func (q *Query[T]) SetPageFromRequest(currentPage uint, itemsPerPage uint) *Query[T] {
+	q = q.edit()
 	if currentPage < 1 {
 		currentPage = 1
 	}
@@ -284,6 +424,7 @@ func (q *Query[T]) SetPageFromRequest(currentPage uint, itemsPerPage uint) *Quer
 }
 
 func (q *Query[T]) GroupBy(column ...Column) *Query[T] {
+	q = q.edit()
 	for _, c := range column {
 		if c.Name == "" {
 			continue
@@ -303,7 +444,56 @@ func (q *Query[T]) GroupBy(column ...Column) *Query[T] {
 	return q
 }
 
+// Having filters the groups of a grouped query, statements are joined with AND. Aggregated columns are
+// referenced by their As or GroupByName alias when they have one.
+func (q *Query[T]) Having(column Column, conditional string, value interface{}) *Query[T] {
+	q = q.edit()
+	if column.Name == "" {
+		return q.missingColumn()
+	}
+	stmt := Cond(column, conditional, value)
+	if _, _, err := stmt.bind("having_" + column.Name); err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return q
+	}
+	if _, isSubquery := value.(Subquery); isSubquery || strings.Contains(conditional, "in") {
+		q.cansave = false
+	}
+	q.HavingStmts = append(q.HavingStmts, stmt)
+	return q
+}
+
+// renderHaving returns the rendered having statements and the values of their parameters
+func (q *Query[T]) renderHaving() ([]string, map[string]interface{}) {
+	isGroupBy := len(q.GroupByStmt) > 0
+	params := newExprParams(nil)
+	var parts []string
+	for _, h := range q.HavingStmts {
+		stmt := *h
+		stmt.left = h.LeftValue.havingName(isGroupBy)
+		// statements are checked when they are added
+		formatted, args, err := stmt.bind(params.reserve("having_" + h.LeftValue.Name))
+		if err != nil {
+			continue
+		}
+		params.add(args)
+		parts = append(parts, formatted)
+	}
+	return parts, params.args
+}
+
+func (q *Query[T]) havingClause() string {
+	parts, _ := q.renderHaving()
+	if len(parts) == 0 {
+		return ""
+	}
+	return "HAVING " + strings.Join(parts, " AND ")
+}
+
 func (q *Query[T]) OrderBy(column ...Column) *Query[T] {
+	q = q.edit()
 	for _, c := range column {
 		if c.Name == "" {
 			continue
@@ -314,31 +504,53 @@ func (q *Query[T]) OrderBy(column ...Column) *Query[T] {
 }
 
 func (q *Query[T]) SetCacheDuration(duration time.Duration) *Query[T] {
+	q = q.edit()
 	q.CacheDuration = duration
 	return q
 }
 
 func (q *Query[T]) Limit(limit int) *Query[T] {
+	q = q.edit()
 	q.LimitCount = limit
 	return q
 }
 
 func (q *Query[T]) SetCache(cache ctx_cache.Cache) *Query[T] {
+	q = q.edit()
 	q.Cache = cache
 	return q
 }
 
 func (q *Query[T]) UseCache() *Query[T] {
+	q = q.edit()
 	q.useCache = true
 	return q
 }
 
+// StaleWhileRevalidate keeps serving a cached result for the duration after it expires while it is refreshed in the background
+func (q *Query[T]) StaleWhileRevalidate(duration time.Duration) *Query[T] {
+	q = q.edit()
+	q.staleDuration = duration
+	return q
+}
+
+// CacheEmptyResults caches queries that return no rows for the duration, empty results are not cached by default
+func (q *Query[T]) CacheEmptyResults(duration time.Duration) *Query[T] {
+	q = q.edit()
+	q.negativeCacheDuration = duration
+	return q
+}
+
 func (q *Query[T]) Refresh(refresh bool) *Query[T] {
+	q = q.edit()
 	q.refreshCache = refresh
 	return q
 }
 
 func (q *Query[T]) Build() *Query[T] {
+	if q.immutable {
+		q = q.Clone()
+	}
 	switch q.FromTable.QueryType {
 	case QueryTypeFireBase:
 	case QueryTypeSQL:
@@ -350,7 +562,11 @@ func (q *Query[T]) Build() *Query[T] {
 	}
 	return q
 }
+
+// SetName saves the built sql under the name so later queries with the name skip building it, the name must
+// identify a single query shape
 func (q *Query[T]) SetName(name string) *Query[T] {
+	q = q.edit()
 	q.Name = name
 	return q
 }
@@ -383,8 +599,9 @@ func (q *Query[T]) RunMap(ctx context.Context, db DB, args ...interface{}) (map[
 	if err != nil {
 		return nil, err
 	}
-	if len(q.MapKeyColumns) == 0 {
-		q.MapKeyColumns = append(q.MapKeyColumns, q.FromTable.GetPrimary()...)
+	keyColumns := q.MapKeyColumns
+	if len(keyColumns) == 0 {
+		keyColumns = q.FromTable.GetPrimary()
 	}
 	m := map[string]*T{}
 
@@ -393,7 +610,7 @@ func (q *Query[T]) RunMap(ctx context.Context, db DB, args ...interface{}) (map[
 		ps := reflect.ValueOf(row)
 		// struct
 		s := ps.Elem()
-		for _, column := range q.MapKeyColumns {
+		for _, column := range keyColumns {
 			if s.Kind() == reflect.Struct {
 				f := s.FieldByName(column.Name)
 				if f.IsValid() {
@@ -421,6 +638,7 @@ func (q *Query[T]) RunCtx(ctx context.Context) ([]*T, error) {
 }
 
 func (q *Query[T]) Prefix(group string) *Query[T] {
+	q = q.edit()
 	q.tmpPrefix = group
 	return q
 }
@@ -430,40 +648,29 @@ func (q *Query[T]) Run(ctx context.Context, db DB, args ...interface{}) ([]*T, e
 		return nil, q.err
 	}
 	if len(q.Query) == 0 {
-		q.Build()
+		q = q.Build()
+	}
+	if q.err != nil {
+		return nil, q.err
 	}
 	ctx = CtxWithQueryTag(ctx, q.getName())
 	cacheKey := q.GetCacheKey(args...)
 
-	if q.useCache || q.Cache != nil {
+	if (q.useCache || q.Cache != nil) && !q.skipCache {
 		tracer := otel.GetTracerProvider()
 		ctx, span := tracer.Tracer("query-ctx").Start(ctx, fmt.Sprintf("%s-%s", q.Name, q.FromTable.FullTableName()))
 		defer span.End()
-		return ctx_cache.GetSetCheck[[]*T](ctx, q.CacheDuration, q.FromTable.FullTableName()+q.tmpPrefix, cacheKey, q.refreshCache, func(ctx context.Context, data *[]*T) bool {
-			if data == nil {
-				return false
-			}
-			return len(*data) > 0
-		},
-
-			func(ctx context.Context) ([]*T, error) {
-				if q.NoLock || q.ReadPast {
-					return q.FromTable.UseNoLock().NamedSelect(ctx, db, q.Query, q.Args(args))
-				}
-				return q.FromTable.NamedSelect(ctx, db, q.Query, q.Args(args))
-			})
+		return cachedFetch[[]*T](ctx, q.cacheOptions(), q.FromTable.FullTableName()+q.tmpPrefix, cacheKey, func(data []*T) bool {
+			return len(data) == 0
+		}, func(ctx context.Context) ([]*T, error) {
+			return q.selectRows(ctx, db, args)
+		})
 	}
 
 	tracer := otel.GetTracerProvider()
 	ctx, span := tracer.Tracer("query").Start(ctx, fmt.Sprintf("%s-%s", q.Name, q.FromTable.FullTableName()))
 	defer span.End()
-	var data []*T
-	var err error
-	if q.NoLock || q.ReadPast {
-		data, err = q.FromTable.UseNoLock().NamedSelect(ctx, db, q.Query, q.Args(args))
-	} else {
-		data, err = q.FromTable.NamedSelect(ctx, db, q.Query, q.Args(args))
-	}
+	data, err := q.selectRows(ctx, db, args)
 
 	if err != nil {
 		ctxLogger.Error(ctx, "query error", zap.Error(err), zap.String("query", q.Query))
@@ -476,10 +683,50 @@ func (q *Query[T]) Run(ctx context.Context, db DB, args ...interface{}) ([]*T, e
 func (q *Query[T]) Args(args ...interface{}) map[string]interface{} {
 	whereArgs := map[string]interface{}{}
 	for _, where := range q.WhereStmts {
-		if k, arg := where.GetArg(); arg != nil || k != "" {
+		_, stmtArgs, _ := where.bind(where.paramName())
+		for k, arg := range stmtArgs {
+			// nil values are passed when the query is run
+			if arg != nil {
+				whereArgs[k] = arg
+			}
+		}
+	}
+	_, exprArgs := q.renderWhereExprs()
+	for k, arg := range exprArgs {
+		whereArgs[k] = arg
+	}
+	_, havingArgs := q.renderHaving()
+	for k, arg := range havingArgs {
+		whereArgs[k] = arg
+	}
+	_, subqueryArgs := q.renderSelectSubqueries()
+	for k, arg := range subqueryArgs {
+		whereArgs[k] = arg
+	}
+	_, setArgs := q.renderSetOperations()
+	for k, arg := range setArgs {
+		whereArgs[k] = arg
+	}
+	for i, join := range q.JoinStmt {
+		if join.table == nil {
+			continue
+		}
+		_, joinArgs := q.renderJoin(i, join)
+		for k, arg := range joinArgs {
 			whereArgs[k] = arg
 		}
 	}
+	_, withArgs := q.renderWith()
+	for k, arg := range withArgs {
+		whereArgs[k] = arg
+	}
+	if q.FromQuery != nil {
+		if _, fromArgs, err := renamedSubquery(q.FromQuery, "from_"); err == nil {
+			for k, arg := range fromArgs {
+				whereArgs[k] = arg
+			}
+		}
+	}
 	arg, err := combineStructs(append(args, whereArgs)...)
 	if err != nil {
 		return nil
@@ -487,32 +734,9 @@ func (q *Query[T]) Args(args ...interface{}) map[string]interface{} {
 	return arg
 }
 
+// GetCacheKey hashes the canonical encoding of the query, see cacheKeyString
 func (q *Query[T]) GetCacheKey(args ...interface{}) string {
-	var keys []string
-	argsData := q.Args(args...)
-
-	keys = append(keys, q.FromTable.FullTableName())
-	keys = append(keys, strconv.FormatBool(q.NoLock))
-	keys = append(keys, strconv.FormatBool(q.ReadPast))
-	for _, k := range q.SelectColumns {
-		keys = append(keys, k.Name)
-	}
-	for _, k := range q.WhereStmts {
-		keys = append(keys, k.ToString())
-	}
-	for _, k := range q.GroupByStmt {
-		keys = append(keys, k.FullTableName())
-	}
-	for _, k := range q.OrderByStmt {
-		keys = append(keys, k.FullTableName())
-	}
-	keys = append(keys, strconv.Itoa(q.Pagination.Offset))
-	keys = append(keys, strconv.Itoa(q.Pagination.Limit))
-	for k, v := range argsData {
-		keys = append(keys, fmt.Sprintf("%s:%s", k, safeString(v)))
-	}
-
-	return GetMD5Hash(strings.Join(keys, ""))
+	return GetMD5Hash(q.cacheKeyString(args...))
 }
 
 func GetMD5Hash(text string) string {
@@ -524,46 +748,51 @@ type TotalRows struct {
 	Total int `json:"total" db:"total"`
 }
 
+func (q *Query[T]) totalRowsQuery(distintColumns *Column) string {
+	with, _ := q.renderWith()
+	return with + q.countStatement(distintColumns)
+}
+
+// countStatement counts the rows of the query without its with clause
+func (q *Query[T]) countStatement(distintColumns *Column) string {
+	switch {
+	case len(q.setOperations) > 0 || q.hasFullJoin():
+		return fmt.Sprintf("SELECT\n\tcount(*) as total\nFROM\n\t(%s) AS set_rows", strings.ReplaceAll(q.setStatement(), "\n", "\n\t"))
+	case len(q.GroupByStmt) > 0 || len(q.HavingStmts) > 0:
+		// grouped queries return a row per group, having may filter on aliases of the select list
+		return fmt.Sprintf("SELECT\n\tcount(*) as total\nFROM\n\t(%s) AS grouped_rows", strings.ReplaceAll(q.selectStatement(), "\n", "\n\t"))
+	case q.distinct:
+		// counting the distinct rows keeps rows with null values, count(DISTINCT ...) would skip them
+		return fmt.Sprintf("SELECT\n\tcount(*) as total\nFROM\n\t(%s) AS distinct_rows", strings.ReplaceAll(q.selectStatement(), "\n", "\n\t"))
+	case distintColumns == nil:
+		return fmt.Sprintf("SELECT\n\tcount(*) as total\n%s", q.fromStatement())
+	default:
+		return fmt.Sprintf("SELECT\n\tcount(DISTINCT %s) as total\n%s", distintColumns.FullName(false, false), q.fromStatement())
+	}
+}
+
 func (q *Query[T]) TotalRows(ctx context.Context, distintColumns *Column) (int, error) {
-	var query string
 	if q.err != nil {
 		return -1, q.err
 	}
-	if distintColumns == nil {
-		query = fmt.Sprintf("SELECT\n\tcount(*) as total\nFROM\n\t%s", q.FromTable.FullTableName())
-	} else {
-		query = fmt.Sprintf("SELECT\n\tcount(%s) as total\nFROM\n\t%s", distintColumns.FullName(false, false), q.FromTable.FullTableName())
-	}
-
-	if len(q.JoinStmt) > 0 {
-		for _, join := range q.JoinStmt {
-			overlappingColumns := map[string]Column{}
-			overlappingColumns = JoinMaps[Column](overlappingColumns, q.FromTable.GetCommonColumns(join.Columns))
-			if len(overlappingColumns) == 0 {
-				continue
-			}
-			query = fmt.Sprintf("%s\n%s", query, q.FromTable.generateJoinStmt(overlappingColumns, join.JoinType))
+	query := q.totalRowsQuery(distintColumns)
+	// the count query depends on the distinct column, so it is prepared under its own name
+	name := q.statementName()
+	if name != "" {
+		name += ":total"
+		if distintColumns != nil {
+			name += ":" + distintColumns.Name
 		}
 	}
-
-	if len(q.WhereStmts) > 0 {
-		query = fmt.Sprintf("%s\n%s", query, generateWhere(q.WhereStmts))
-	}
-
-	if len(q.GroupByStmt) > 0 {
-		query = fmt.Sprintf("%s\n%s", query, generateGroupBy(q.GroupByStmt))
-	}
-
-	if len(q.OrderByStmt) > 0 {
-		query = fmt.Sprintf("%s\n%s", query, q.FromTable.OrderByColumns(len(q.GroupByStmt) > 0, q.OrderByStmt...))
-	}
 	cacheKey := q.GetCacheKey() + "_total"
-	if q.useCache {
+	if q.useCache && !q.skipCache {
 		tracer := otel.GetTracerProvider()
 		ctx, span := tracer.Tracer("query-ctx").Start(ctx, fmt.Sprintf("%s-%s", q.Name, q.FromTable.FullTableName()))
 		defer span.End()
-		return ctx_cache.GetSet[int](ctx, q.CacheDuration, q.FromTable.FullTableName(), cacheKey, q.refreshCache, func(ctx context.Context) (int, error) {
-			db, err := q.FromTable.NamedQuery(ctx, nil, query, q.Args())
+		return cachedFetch[int](ctx, q.cacheOptions(), q.FromTable.FullTableName(), cacheKey, func(int) bool {
+			return false
+		}, func(ctx context.Context) (int, error) {
+			db, err := preparedNamedQuery(ctx, q.FromTable.db, name, query, &DBOptions{NoLock: q.FromTable.useNoLock}, q.Args())
 			if err != nil {
 				return -1, err
 			}
@@ -577,7 +806,7 @@ func (q *Query[T]) TotalRows(ctx context.Context, distintColumns *Column) (int,
 			return t.Total, nil
 		})
 	}
-	db, err := q.FromTable.NamedQuery(ctx, nil, query, q.Args())
+	db, err := preparedNamedQuery(ctx, q.FromTable.db, name, query, &DBOptions{NoLock: q.FromTable.useNoLock}, q.Args())
 	if err != nil {
 		return -1, err
 	}
@@ -591,31 +820,41 @@ func (q *Query[T]) TotalRows(ctx context.Context, distintColumns *Column) (int,
 	return t.Total, nil
 }
 
-func (q *Query[T]) buildSqlQuery() *Query[T] {
-	if q.err != nil {
-		return q
-	}
-	if q.hasSaved() {
-		if v, found := QueryPrepare[q.Name]; found {
-			q.Query = v
-			return q
-		}
-	}
+// selectStatement renders the query without its order and limit
+func (q *Query[T]) selectStatement() string {
 	var isGroupBy = len(q.GroupByStmt) > 0
-	var query string
-	selectColumns := q.FromTable.GetSelectableColumns(isGroupBy, q.SelectColumns...)
-	withSelect := ""
-	if q.FromQuery != nil {
-		q.FromQuery.Build()
-		query = fmt.Sprintf("SELECT\n\t%s\nFROM\n\t(%s) %s", strings.Join(selectColumns, ",\n\t"), strings.ReplaceAll(q.FromQuery.Query, "\n", "\n\t"), withSelect)
-
-	} else {
-		query = fmt.Sprintf("SELECT\n\t%s\nFROM\n\t%s%s", strings.Join(selectColumns, ",\n\t"), q.FromTable.FullTableName(), withSelect)
+	columns := append(append([]Column{}, q.SelectColumns...), q.DistinctSelectColumns...)
+	selectColumns := q.FromTable.GetSelectableColumns(isGroupBy, columns...)
+	subqueryColumns, _ := q.renderSelectSubqueries()
+	selectColumns = append(selectColumns, subqueryColumns...)
+	keyword := "SELECT"
+	if q.distinct {
+		keyword = "SELECT DISTINCT"
+	}
+	return fmt.Sprintf("%s\n\t%s\n%s", keyword, strings.Join(selectColumns, ",\n\t"), q.fromStatement())
+}
 
+// fromStatement renders the from, join, where, group by and having clauses shared by the select and count queries
+func (q *Query[T]) fromStatement() string {
+	var query string
+	switch {
+	case q.fromWith != "":
+		query = fmt.Sprintf("FROM\n\t%s AS %s", q.fromWith, q.FromTable.Name)
+	case q.FromQuery != nil:
+		// derived tables are aliased as the table so the columns of the table reference them, the query is checked by From
+		from, _, _ := renamedSubquery(q.FromQuery, "from_")
+		query = fmt.Sprintf("FROM\n\t(%s) AS %s", strings.ReplaceAll(from, "\n", "\n\t"), q.FromTable.Name)
+	default:
+		query = fmt.Sprintf("FROM\n\t%s", q.FromTable.FullTableName())
 	}
 
 	if len(q.JoinStmt) > 0 {
-		for _, join := range q.JoinStmt {
+		for i, join := range q.JoinStmt {
+			if join.table != nil {
+				clause, _ := q.renderJoin(i, join)
+				query = fmt.Sprintf("%s\n%s", query, clause)
+				continue
+			}
 			overlappingColumns := map[string]Column{}
 			overlappingColumns = JoinMaps[Column](overlappingColumns, q.FromTable.GetCommonColumns(join.Columns))
 			if len(overlappingColumns) == 0 {
@@ -625,14 +864,37 @@ func (q *Query[T]) buildSqlQuery() *Query[T] {
 		}
 	}
 
-	if len(q.WhereStmts) > 0 {
-		query = fmt.Sprintf("%s\n%s", query, generateWhere(q.WhereStmts))
+	if where := q.whereClause(); where != "" {
+		query = fmt.Sprintf("%s\n%s", query, where)
 	}
 
 	if len(q.GroupByStmt) > 0 {
 		query = fmt.Sprintf("%s\n%s", query, generateGroupBy(q.GroupByStmt))
 	}
 
+	if having := q.havingClause(); having != "" {
+		query = fmt.Sprintf("%s\n%s", query, having)
+	}
+	return query
+}
+
+func (q *Query[T]) buildSqlQuery() *Query[T] {
+	if q.err != nil {
+		return q
+	}
+	if q.canSave() {
+		if v, found := preparedQueries.Query(q.statementName(), q.dialect()); found {
+			q.Query = v
+			return q
+		}
+	}
+	with, _ := q.renderWith()
+	if q.hasFullJoin() && (len(q.GroupByStmt) > 0 || len(q.HavingStmts) > 0) {
+		q.err = fmt.Errorf("%w: queries with a full join can not be grouped", ErrInvalidJoin)
+		return q
+	}
+	query := with + q.setStatement()
+
 	if len(q.OrderByStmt) > 0 {
 		query = fmt.Sprintf("%s\n%s", query, q.FromTable.OrderByColumns(len(q.GroupByStmt) > 0, q.OrderByStmt...))
 	}
@@ -646,26 +908,49 @@ func (q *Query[T]) buildSqlQuery() *Query[T] {
 	}
 	q.Query = query
 	if q.canSave() {
-		QueryPrepare[q.Name] = query
+		preparedQueries.SetQuery(q.statementName(), q.dialect(), query)
 	}
 	return q
 }
 
+// namedQuery runs the built query, saved named queries reuse a prepared statement
+func (q *Query[T]) namedQuery(ctx context.Context, db DB, options *DBOptions, args ...interface{}) (DBRow, error) {
+	return preparedNamedQuery(ctx, db, q.statementName(), q.Query, options, args...)
+}
+
+// selectRows runs the built query for Run, saved named queries reuse a prepared statement
+func (q *Query[T]) selectRows(ctx context.Context, db DB, args []interface{}) ([]*T, error) {
+	table := q.FromTable
+	if q.NoLock || q.ReadPast {
+		table = table.UseNoLock()
+	}
+	a, err := combineStructs(q.Args(args))
+	if err != nil {
+		return nil, err
+	}
+	return table.selectArgs(ctx, db, q.statementName(), q.Query, a)
+}
+
 func SelectQuery[T any, X any](ctx context.Context, db DB, q *Query[T], options *DBOptions, args ...interface{}) ([]*X, error) {
 	if len(q.Query) == 0 {
-		q.Build()
+		q = q.Build()
+	}
+	if q.err != nil {
+		return nil, q.err
 	}
 	if db == nil {
 		db = q.FromTable.db
 	}
-	if q.useCache {
+	if q.useCache && !q.skipCache {
 		cacheKey := q.GetCacheKey(args...)
 		tracer := otel.GetTracerProvider()
 		ctx, span := tracer.Tracer("select-query-ctx").Start(ctx, fmt.Sprintf("%s-%s", q.Name, q.FromTable.FullTableName()))
 		defer span.End()
 
-		return ctx_cache.GetSet[[]*X](ctx, q.CacheDuration, q.FromTable.FullTableName()+q.tmpPrefix, cacheKey, q.refreshCache, func(ctx context.Context) ([]*X, error) {
-			rows, err := NamedQuery(ctx, db, q.Query, options, q.Args(args...))
+		return cachedFetch[[]*X](ctx, q.cacheOptions(), q.FromTable.FullTableName()+q.tmpPrefix, cacheKey, func(data []*X) bool {
+			return len(data) == 0
+		}, func(ctx context.Context) ([]*X, error) {
+			rows, err := q.namedQuery(ctx, db, options, q.Args(args...))
 			if err != nil {
 				return nil, err
 			}
@@ -679,12 +964,15 @@ func SelectQuery[T any, X any](ctx context.Context, db DB, q *Query[T], options
 				if err != nil {
 					return nil, err
 				}
+				if err := runHook(ctx, hookAfterFind, &tmp); err != nil {
+					return nil, err
+				}
 				output = append(output, &tmp)
 			}
 			return output, nil
 		})
 	}
-	rows, err := NamedQuery(ctx, db, q.Query, options, q.Args(args...))
+	rows, err := q.namedQuery(ctx, db, options, q.Args(args...))
 	if err != nil {
 		return nil, err
 	}
@@ -698,6 +986,9 @@ func SelectQuery[T any, X any](ctx context.Context, db DB, q *Query[T], options
 		if err != nil {
 			return nil, err
 		}
+		if err := runHook(ctx, hookAfterFind, &tmp); err != nil {
+			return nil, err
+		}
 		output = append(output, &tmp)
 	}
 	return output, nil
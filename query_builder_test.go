@@ -2,8 +2,11 @@ package QueryHelper
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -251,3 +254,671 @@ func BenchmarkCombineStructsInsert(b *testing.B) {
 	}
 
 }
+
+func TestQuery_Clone(t *testing.T) {
+	table, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := QueryTable[Resource](table)
+	base.Where(base.Column("public"), "=", "AND", 0, true).Build()
+
+	clone := base.Clone()
+	clone.Where(clone.Column("resource_type"), "=", "AND", 0, "url").Build()
+	if len(base.WhereStmts) != 1 || strings.Contains(base.Query, ":resource_type") {
+		t.Fatal("expected changes to the clone to leave the original unchanged")
+	}
+	if !strings.Contains(clone.Query, ":resource_type") {
+		t.Fatalf("expected the clone to be rebuilt, got %s", clone.Query)
+	}
+}
+
+func TestQuery_ImmutableConcurrent(t *testing.T) {
+	table, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := QueryTable[Resource](table).Immutable()
+	base = base.Where(base.Column("public"), "=", "AND", 0, true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q := base.Where(base.Column("id"), "=", "AND", 0, fmt.Sprint(i)).Limit(1).Build()
+			if len(q.WhereStmts) != 2 || !strings.Contains(q.Query, "resource.id = :id") || !strings.Contains(q.Query, "LIMIT 1") {
+				t.Errorf("unexpected query %s", q.Query)
+			}
+			if q.Args()["id"] != fmt.Sprint(i) {
+				t.Errorf("unexpected args %v", q.Args())
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(base.WhereStmts) != 1 || base.LimitCount != 0 || base.Query != "" {
+		t.Fatal("expected the shared base query to be unchanged")
+	}
+	if q := base.Where(base.Column("missing"), "=", "AND", 0, 1); q.err == nil || base.err != nil {
+		t.Fatal("expected a missing column to fail the derived query only")
+	}
+}
+
+func TestQuery_WhereExpr(t *testing.T) {
+	table, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := QueryTable[Resource](table)
+	id, rt, pub := q.Column("id"), q.Column("resource_type"), q.Column("public")
+	q.Where(pub, "=", "AND", 0, true).
+		WhereExpr(And(
+			Cond(id, "=", "a"),
+			Or(Cond(rt, "=", "url"), Cond(rt, "=", "file")),
+			Or(Cond(id, "=", "b"), And(Cond(rt, "!=", "url"), Not(Cond(pub, "=", false)))),
+		))
+
+	expected := "WHERE (resource.public = :public) AND (resource.id = :id AND " +
+		"(resource.resource_type = :resource_type OR resource.resource_type = :resource_type_1) AND " +
+		"(resource.id = :id_1 OR (resource.resource_type != :resource_type_2 AND NOT (resource.public = :public_1))))"
+	if where := q.whereClause(); where != expected {
+		t.Fatalf("unexpected where clause\nexpected: %s\nactual:   %s", expected, where)
+	}
+	args := q.Args()
+	for k, v := range map[string]interface{}{
+		"public": true, "public_1": false, "id": "a", "id_1": "b",
+		"resource_type": "url", "resource_type_1": "file", "resource_type_2": "url",
+	} {
+		if args[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, args[k])
+		}
+	}
+}
+
+func TestQuery_WhereWithExpr(t *testing.T) {
+	table, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := QueryTable[Resource](table)
+	id, rt := q.Column("id"), q.Column("resource_type")
+	q = q.Where(id, "=", "AND", 0, "a").
+		Where(Column{}, "", "AND", 0, Or(Cond(rt, "=", "url"), Not(Cond(rt, "=", "file"))))
+
+	expected := "WHERE (resource.id = :id) AND (resource.resource_type = :resource_type OR NOT (resource.resource_type = :resource_type_1))"
+	if where := q.whereClause(); where != expected {
+		t.Fatalf("unexpected where clause\nexpected: %s\nactual:   %s", expected, where)
+	}
+	if args := q.Args(); args["resource_type"] != "url" || args["resource_type_1"] != "file" {
+		t.Fatalf("unexpected args %v", args)
+	}
+
+	invalid := QueryTable[Resource](table)
+	if invalid = invalid.Where(Column{}, "", "AND", 0, And(Cond(invalid.Column("data"), "is", "value"))); !errors.Is(invalid.err, ErrInvalidCondition) {
+		t.Fatalf("expected invalid condition error, got %v", invalid.err)
+	}
+}
+
+func TestQuery_WhereExprInvalid(t *testing.T) {
+	table, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := QueryTable[Resource](table)
+	q.WhereExpr(Or(Cond(q.Column("id"), "=", "a"), Cond(q.Column("data"), "is", "value")))
+	if !errors.Is(q.err, ErrInvalidCondition) {
+		t.Fatalf("expected invalid condition error, got %v", q.err)
+	}
+
+	legacy := QueryTable[Resource](table)
+	legacy.Where(legacy.Column("data"), "is", "AND", 0, "value")
+	if !errors.Is(legacy.err, ErrInvalidCondition) || len(legacy.WhereStmts) != 0 {
+		t.Fatalf("expected invalid level based statement to fail the query, got %v", legacy.err)
+	}
+	unknown := QueryTable[Resource](table)
+	unknown.Where(unknown.Column("data"), "regexp", "AND", 0, "value")
+	if !errors.Is(unknown.err, ErrUnknownOperator) {
+		t.Fatalf("expected unknown operator error, got %v", unknown.err)
+	}
+
+	// statements that do not render do not leave a dangling join operator
+	where := whereConditions([]*WhereStmt{
+		{LeftValue: table.GetColumn("data"), Conditional: "is", RightValue: "value"},
+		{LeftValue: table.GetColumn("id"), Conditional: "=", RightValue: "a", JoinOperator: "OR"},
+	})
+	if where != " resource.id = :id" {
+		t.Fatalf("unexpected where conditions %q", where)
+	}
+}
+
+func TestQuery_Predicates(t *testing.T) {
+	table, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers, err := NewTable[Answer]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := QueryTable[Answer](answers)
+	sub.Select(sub.Column("uid")).Where(sub.Column("question_id"), "=", "AND", 0, "q1")
+
+	q := QueryTable[Resource](table)
+	id, data, desc := q.Column("id"), q.Column("data"), q.Column("description")
+	q.Where(id, "=", "AND", 0, "a").
+		WhereExpr(And(
+			Between(q.Column("created_timestamp"), "2024-01-01", "2024-02-01"),
+			NotBetween(id, "a", "c"),
+			Or(StartsWith(data, "50%_off"), Contains(data, "!"), ILike(desc, "Sale%")),
+			IsNotNull(desc),
+			IsDistinctFrom(q.Column("public"), true),
+			Exists(sub),
+			NotExists(sub),
+		))
+	if q.err != nil {
+		t.Fatal(q.err)
+	}
+	expected := "WHERE (resource.id = :id) AND (" +
+		"resource.created_timestamp BETWEEN :created_timestamp_from AND :created_timestamp_to AND " +
+		"resource.id NOT BETWEEN :id_1_from AND :id_1_to AND " +
+		"(resource.data LIKE :data ESCAPE '!' OR resource.data LIKE :data_1 ESCAPE '!' OR LOWER(resource.description) LIKE LOWER(:description)) AND " +
+		"resource.description IS NOT NULL AND " +
+		"NOT (resource.public <=> :public) AND " +
+		"EXISTS (SELECT\n\tanswer.uid\nFROM\n\ttest.answer\nWHERE  answer.question_id = :exists_question_id) AND " +
+		"NOT EXISTS (SELECT\n\tanswer.uid\nFROM\n\ttest.answer\nWHERE  answer.question_id = :exists_1_question_id))"
+	if where := q.whereClause(); where != expected {
+		t.Fatalf("unexpected where clause\nexpected: %s\nactual:   %s", expected, where)
+	}
+	args := q.Args()
+	for k, v := range map[string]interface{}{
+		"id": "a", "id_1_from": "a", "id_1_to": "c",
+		"created_timestamp_from": "2024-01-01", "created_timestamp_to": "2024-02-01",
+		"data": "50!%!_off%", "data_1": "%!!%", "description": "Sale%", "public": true,
+		"exists_question_id": "q1", "exists_1_question_id": "q1",
+	} {
+		if args[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, args[k])
+		}
+	}
+	if tags := q.cacheTags(); len(tags) != 1 || tags[0] != answers.FullTableName() {
+		t.Fatalf("expected the subquery table to tag the cache key, got %v", tags)
+	}
+	if len(sub.WhereStmts) != 1 || sub.Query != "" {
+		t.Fatal("expected building the subquery to leave it unchanged")
+	}
+}
+
+func TestQuery_ExistsStatements(t *testing.T) {
+	table, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers, err := NewTable[Answer]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := QueryTable[Answer](answers)
+	first.Select(first.Column("uid")).Where(first.Column("question_id"), "=", "AND", 0, "q1")
+	second := QueryTable[Answer](answers)
+	second.Select(second.Column("uid")).Where(second.Column("question_id"), "=", "AND", 0, "q2")
+
+	// the statements are added the way ListCtx adds them
+	q := QueryTable[Resource](table)
+	q.appendWhere(Exists(first))
+	q.appendWhere(NotExists(second))
+	where := q.whereClause()
+	if !strings.Contains(where, ":exists_question_id)") || !strings.Contains(where, ":exists_1_question_id)") {
+		t.Fatalf("expected the exists statements to use different parameters, got %s", where)
+	}
+	args := q.Args()
+	if args["exists_question_id"] != "q1" || args["exists_1_question_id"] != "q2" {
+		t.Fatalf("unexpected args %v", args)
+	}
+}
+
+func TestQuery_Subqueries(t *testing.T) {
+	table, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers, err := NewTable[Answer]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := QueryTable[Answer](answers)
+	sub.Select(sub.Column("uid")).Where(sub.Column("question_id"), "=", "AND", 0, "q1")
+	latest := QueryTable[Answer](answers)
+	latest.Select(latest.Column("uid")).Where(latest.Column("question_id"), "=", "AND", 0, "q2").Limit(1)
+
+	q := QueryTable[Resource](table)
+	q.Where(q.Column("id"), "IN", "AND", 0, sub).
+		Where(q.Column("data"), "=", "AND", 0, latest).
+		SelectSubquery(latest, "latest_uid").
+		Build()
+	if q.err != nil {
+		t.Fatal(q.err)
+	}
+	for _, expected := range []string{
+		"resource.id IN (SELECT\n\tanswer.uid\nFROM\n\ttest.answer\nWHERE  answer.question_id = :id_question_id)",
+		"resource.data = (SELECT\n\tanswer.uid\nFROM\n\ttest.answer\nWHERE  answer.question_id = :data_question_id\nLIMIT 1)",
+		"(SELECT\n\t\tanswer.uid\n\tFROM\n\t\ttest.answer\n\tWHERE  answer.question_id = :select_latest_uid_question_id\n\tLIMIT 1) AS latest_uid",
+	} {
+		if !strings.Contains(q.Query, expected) {
+			t.Errorf("expected query to contain %q, got %s", expected, q.Query)
+		}
+	}
+	args := q.Args()
+	if args["id_question_id"] != "q1" || args["data_question_id"] != "q2" || args["select_latest_uid_question_id"] != "q2" {
+		t.Fatalf("unexpected args %v", args)
+	}
+	if _, found := args["id"]; found {
+		t.Fatal("expected the subquery itself not to be bound")
+	}
+
+	invalid := QueryTable[Resource](table)
+	invalid.Where(invalid.Column("id"), "like", "AND", 0, sub)
+	if !errors.Is(invalid.err, ErrInvalidCondition) {
+		t.Fatalf("expected subqueries to be rejected for like, got %v", invalid.err)
+	}
+}
+
+func TestFixArrays_DoesNotInlineSelect(t *testing.T) {
+	args := map[string]interface{}{"id": "SELECT id FROM users"}
+	query := fixArrays("SELECT * FROM t WHERE id IN (:id)", args)
+	if strings.Contains(query, "FROM users") || args["id_0"] != "SELECT id FROM users" {
+		t.Fatalf("expected select strings to be bound as values, got %s %v", query, args)
+	}
+}
+
+func TestQuery_Having(t *testing.T) {
+	table, err := NewTable[Log]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := QueryTable[Log](table)
+	q.Select(q.Column("id"), q.Column("account_id"), q.Column("created_timestamp")).
+		Where(q.Column("service"), "=", "AND", 0, "api").
+		GroupBy(q.Column("account_id"), q.Column("created_timestamp")).
+		Having(q.Column("id"), ">", 5).
+		Having(q.Column("created_timestamp"), ">=", "2024-01-01").
+		Build()
+	if q.err != nil {
+		t.Fatal(q.err)
+	}
+	having := "GROUP BY account_id,created_date\nHAVING count(log.id) > :having_id AND created_date >= :having_created_timestamp"
+	if !strings.Contains(q.Query, having) {
+		t.Fatalf("expected having after group by, got %s", q.Query)
+	}
+	if args := q.Args(); args["having_id"] != float64(5) || args["having_created_timestamp"] != "2024-01-01" || args["service"] != "api" {
+		t.Fatalf("unexpected args %v", args)
+	}
+	total := q.totalRowsQuery(nil)
+	if !strings.HasPrefix(total, "SELECT\n\tcount(*) as total\nFROM\n\t(SELECT") || !strings.Contains(total, "\tHAVING count(log.id) > :having_id") {
+		t.Fatalf("expected total rows to count the filtered groups, got %s", total)
+	}
+
+	other := q.Clone()
+	other.HavingStmts = nil
+	other.Having(other.Column("id"), ">", 10)
+	if q.GetCacheKey() == other.GetCacheKey() {
+		t.Fatal("expected having to change the cache key")
+	}
+}
+
+func TestQuery_Distinct(t *testing.T) {
+	table, err := NewTable[AccountUserRole]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := QueryTable[AccountUserRole](table)
+	plain := q.Clone().Build()
+	q.Distinct(q.Column("user_id")).
+		Where(q.Column("account_id"), "=", "AND", 0, "1").
+		Build()
+	if q.err != nil {
+		t.Fatal(q.err)
+	}
+	if !strings.HasPrefix(q.Query, "SELECT DISTINCT\n\taccount_user_role.user_id\nFROM") {
+		t.Fatalf("expected distinct select list, got %s", q.Query)
+	}
+	total := q.totalRowsQuery(nil)
+	if !strings.HasPrefix(total, "SELECT\n\tcount(*) as total\nFROM\n\t(SELECT DISTINCT") || !strings.HasSuffix(total, "AS distinct_rows") {
+		t.Fatalf("expected total rows to count the distinct rows, got %s", total)
+	}
+	if q.GetCacheKey() == plain.GetCacheKey() {
+		t.Fatal("expected distinct to change the cache key")
+	}
+
+	all := QueryTable[AccountUserRole](table)
+	all.Distinct().Build()
+	if !strings.HasPrefix(all.Query, "SELECT DISTINCT\n\t") || all.GetCacheKey() == plain.GetCacheKey() {
+		t.Fatalf("expected the whole select list to be distinct, got %s", all.Query)
+	}
+
+	column := plain.Column("user_id")
+	if total := plain.totalRowsQuery(&column); !strings.HasPrefix(total, "SELECT\n\tcount(DISTINCT account_user_role.user_id) as total\nFROM") {
+		t.Fatalf("expected count distinct, got %s", total)
+	}
+}
+
+func TestQuery_SetOperations(t *testing.T) {
+	table, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch := func(resourceType string) *Query[Resource] {
+		q := QueryTable[Resource](table)
+		return q.Select(q.Column("id"), q.Column("data")).Where(q.Column("resource_type"), "=", "AND", 0, resourceType)
+	}
+	q := branch("url")
+	q.UnionAll(branch("file")).Except(branch("hidden")).Limit(10).Build()
+	if q.err != nil {
+		t.Fatal(q.err)
+	}
+	if !strings.HasPrefix(q.Query, "(SELECT\n") ||
+		!strings.Contains(q.Query, ")\nUNION ALL\n(SELECT") ||
+		!strings.Contains(q.Query, ")\nEXCEPT\n(SELECT") ||
+		!strings.HasSuffix(q.Query, ")\nLIMIT 10;") {
+		t.Fatalf("unexpected set operation query %s", q.Query)
+	}
+	for _, param := range []string{":resource_type", ":set_0_resource_type", ":set_1_resource_type"} {
+		if !strings.Contains(q.Query, param+"\n") && !strings.Contains(q.Query, param+")") {
+			t.Fatalf("expected parameter %s in %s", param, q.Query)
+		}
+	}
+	if args := q.Args(); args["resource_type"] != "url" || args["set_0_resource_type"] != "file" || args["set_1_resource_type"] != "hidden" {
+		t.Fatalf("unexpected args %v", args)
+	}
+	if total := q.totalRowsQuery(nil); !strings.HasPrefix(total, "SELECT\n\tcount(*) as total\nFROM\n\t((SELECT") || !strings.HasSuffix(total, "AS set_rows") {
+		t.Fatalf("expected total rows to count the combined rows, got %s", total)
+	}
+
+	other := branch("url")
+	other.UnionAll(branch("image")).Except(branch("hidden")).Limit(10)
+	if q.GetCacheKey() == other.GetCacheKey() {
+		t.Fatal("expected the combined queries to change the cache key")
+	}
+
+	var invalid *Query[Resource]
+	if failed := branch("url").Union(invalid); !errors.Is(failed.err, ErrInvalidCondition) {
+		t.Fatalf("expected nil query to be rejected, got %v", failed.err)
+	}
+}
+
+type Category struct {
+	ID       string `json:"id" db:"id" qc:"primary"`
+	ParentID string `json:"parent_id" db:"parent_id" qc:"update"`
+	Name     string `json:"name" db:"name" qc:"update"`
+}
+
+func TestQuery_With(t *testing.T) {
+	table, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := QueryTable[Resource](table)
+	public.Where(public.Column("public"), "=", "AND", 0, true)
+
+	q := QueryTable[Resource](table)
+	q.With("public_resources", public).FromWith("public_resources").
+		Where(q.Column("resource_type"), "=", "AND", 0, "url").
+		Build()
+	if q.err != nil {
+		t.Fatal(q.err)
+	}
+	if !strings.HasPrefix(q.Query, "WITH public_resources AS (\n\tSELECT") ||
+		!strings.Contains(q.Query, "public = :with_public_resources_public") ||
+		!strings.Contains(q.Query, "FROM\n\tpublic_resources AS resource\n") {
+		t.Fatalf("unexpected common table expression query %s", q.Query)
+	}
+	if args := q.Args(); args["with_public_resources_public"] != true || args["resource_type"] != "url" {
+		t.Fatalf("unexpected args %v", args)
+	}
+	if total := q.totalRowsQuery(nil); !strings.HasPrefix(total, "WITH public_resources AS (") {
+		t.Fatalf("expected total rows to keep the with clause, got %s", total)
+	}
+	if q.GetCacheKey() == QueryTable[Resource](table).FromWith("public_resources").GetCacheKey() {
+		t.Fatal("expected the with clause to change the cache key")
+	}
+
+	for _, name := range []string{"", "bad name", "public_resources"} {
+		if failed := q.Clone().With(name, public); !errors.Is(failed.err, ErrInvalidCondition) {
+			t.Fatalf("expected %q to be rejected, got %v", name, failed.err)
+		}
+	}
+
+	from := QueryTable[Resource](table)
+	from.From(public.Clone().Limit(5)).Build()
+	if !strings.HasSuffix(from.Query, "LIMIT 5) AS resource") || from.Args()["from_public"] != true {
+		t.Fatalf("expected an aliased derived table with its args, got %s %v", from.Query, from.Args())
+	}
+}
+
+func TestTree(t *testing.T) {
+	table, err := NewTable[Category]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTree[Category](table, "id", "missing"); err == nil {
+		t.Fatal("expected missing parent column to be rejected")
+	}
+	tree, err := NewTree[Category](table, "id", "parent_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	descendants := tree.Descendants("root").Build()
+	if descendants.err != nil {
+		t.Fatal(descendants.err)
+	}
+	for _, part := range []string{
+		"WITH RECURSIVE category_tree AS (\n\tSELECT\n\t\tcategory.id,\n\t\tcategory.name,\n\t\tcategory.parent_id,\n\t\t0 AS tree_depth",
+		"WHERE category.id = :with_category_tree_id\n\tUNION ALL",
+		"JOIN category_tree ON category.parent_id = category_tree.id\n)",
+		"FROM\n\tcategory_tree AS category",
+	} {
+		if !strings.Contains(descendants.Query, part) {
+			t.Fatalf("expected %q in %s", part, descendants.Query)
+		}
+	}
+	if descendants.Args()["with_category_tree_id"] != "root" {
+		t.Fatalf("unexpected args %v", descendants.Args())
+	}
+
+	ancestors := tree.MaxDepth(3).Ancestors("leaf").Build()
+	if !strings.Contains(ancestors.Query, "JOIN category_tree ON category.id = category_tree.parent_id\n\tWHERE category_tree.tree_depth < :with_category_tree_max_depth") {
+		t.Fatalf("unexpected ancestors query %s", ancestors.Query)
+	}
+	if descendants.GetCacheKey() == tree.Descendants("other").GetCacheKey() || descendants.GetCacheKey() == tree.Ancestors("root").GetCacheKey() {
+		t.Fatal("expected trees of different nodes and directions to have different cache keys")
+	}
+}
+
+func TestQuery_WindowFunctions(t *testing.T) {
+	table, err := NewTable[Log]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := QueryTable[Log](table)
+	created := q.Column("created_timestamp")
+	created.OrderAsc = true
+	over := PartitionBy(q.Column("account_id")).OrderBy(created)
+	q.Select(
+		q.Column("id"),
+		RowNumber(over),
+		Rank(over).As("log_rank"),
+		Rank(over),
+		DenseRank(over),
+		Lag(created, 1, over).As("previous_timestamp"),
+		AggregateOver("count", q.Column("id"), over),
+	).OrderBy(Rank(over).As("log_rank")).Build()
+	if q.err != nil {
+		t.Fatal(q.err)
+	}
+	for _, part := range []string{
+		"\tROW_NUMBER() OVER (PARTITION BY log.account_id ORDER BY log.created_timestamp ASC) AS row_num,\n",
+		"\tRANK() OVER (PARTITION BY log.account_id ORDER BY log.created_timestamp ASC) AS log_rank,\n",
+		"\tRANK() OVER (PARTITION BY log.account_id ORDER BY log.created_timestamp ASC) AS row_rank,\n",
+		"\tDENSE_RANK() OVER (PARTITION BY log.account_id ORDER BY log.created_timestamp ASC) AS row_dense_rank,\n",
+		"\tLAG(log.created_timestamp, 1) OVER (PARTITION BY log.account_id ORDER BY log.created_timestamp ASC) AS previous_timestamp,\n",
+		"\tCOUNT(log.id) OVER (PARTITION BY log.account_id ORDER BY log.created_timestamp ASC) AS count_id\n",
+		"ORDER BY log_rank DESC",
+	} {
+		if !strings.Contains(q.Query, part) {
+			t.Fatalf("expected %q in %s", part, q.Query)
+		}
+	}
+
+	for _, invalid := range []Column{AggregateOver("sum()", q.Column("id"), over), Lag(Column{}, 1, over), RowNumber(PartitionBy(Column{}))} {
+		if failed := QueryTable[Log](table).Select(invalid); !errors.Is(failed.err, ErrInvalidCondition) {
+			t.Fatalf("expected %s to be rejected, got %v", invalid.Name, failed.err)
+		}
+	}
+}
+
+func TestTopNPerGroup(t *testing.T) {
+	table, err := NewTable[Log]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := QueryTable[Log](table)
+	q.Select(q.Column("id"), q.Column("account_id")).
+		Where(q.Column("service"), "=", "AND", 0, "api").
+		Limit(20)
+	top := TopNPerGroup(q, 3, []Column{q.Column("account_id")}, q.Column("created_timestamp"))
+	top.Select(top.DerivedColumn(TopNRankColumn)).Build()
+	if top.err != nil {
+		t.Fatal(top.err)
+	}
+	for _, part := range []string{
+		"WITH log_ranked AS (\n\tSELECT\n\t\tlog.account_id,\n\t\tlog.created_timestamp,\n\t\tlog.data,\n\t\tlog.id,\n\t\tlog.log_type,\n\t\tlog.service,\n\t\tlog.user_id,\n\t\tROW_NUMBER() OVER (PARTITION BY log.account_id ORDER BY log.created_timestamp DESC) AS group_rank\n",
+		"WHERE  log.service = :with_log_ranked_service\n)",
+		"SELECT\n\tlog.id,\n\tlog.account_id,\n\tlog.group_rank\nFROM\n\tlog_ranked AS log\nWHERE log.group_rank <= :group_rank\nLIMIT 20;",
+	} {
+		if !strings.Contains(top.Query, part) {
+			t.Fatalf("expected %q in %s", part, top.Query)
+		}
+	}
+	if args := top.Args(); args["group_rank"] != float64(3) || args["with_log_ranked_service"] != "api" {
+		t.Fatalf("unexpected args %v", args)
+	}
+	if TopNPerGroup(q, 2, []Column{q.Column("account_id")}, q.Column("created_timestamp")).GetCacheKey() == TopNPerGroup(q, 3, []Column{q.Column("account_id")}, q.Column("created_timestamp")).GetCacheKey() {
+		t.Fatal("expected n to change the cache key")
+	}
+	if failed := TopNPerGroup(q, 0, nil); !errors.Is(failed.err, ErrInvalidCondition) {
+		t.Fatalf("expected n to be positive, got %v", failed.err)
+	}
+
+	type rankedLog struct {
+		ID        string `db:"id"`
+		AccountID string `db:"account_id"`
+		GroupRank int    `db:"group_rank"`
+	}
+	db, _ := newCountingDB()
+	defer db.Close()
+	if _, err := SelectQuery[Log, rankedLog](context.Background(), NewSql(db), top, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuery_JoinOn(t *testing.T) {
+	table, err := NewTable[Category]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resourceTable, err := NewTable[Resource]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := QueryTable[Category](table)
+	parentName := q.Column("name").Of("parent")
+	q.Select(q.Column("id"), parentName.As("parent_name")).
+		JoinOn(table, "parent", And(Cond(q.Column("parent_id"), "=", q.Column("id").Of("parent")), Cond(parentName, "!=", "root")), "left").
+		JoinOn(resourceTable, "r", nil, "cross").
+		Where(q.Column("name"), "=", "AND", 0, "child").
+		Where(parentName, "=", "AND", 0, "parent").
+		Build()
+	if q.err != nil {
+		t.Fatal(q.err)
+	}
+	for _, part := range []string{
+		"\tparent.name AS parent_name\n",
+		"FROM\n\ttest.category\nLEFT JOIN test.category AS parent ON (category.parent_id = parent.id AND parent.name != :join_0_parent_name)\nCROSS JOIN test.resource AS r\n",
+		"category.name = :name AND parent.name = :parent_name",
+	} {
+		if !strings.Contains(q.Query, part) {
+			t.Fatalf("expected %q in %s", part, q.Query)
+		}
+	}
+	if args := q.Args(); args["join_0_parent_name"] != "root" || args["name"] != "child" || args["parent_name"] != "parent" {
+		t.Fatalf("unexpected args %v", args)
+	}
+	if tags := q.cacheTags(); len(tags) != 1 || tags[0] != "test.resource" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	full := QueryTable[Category](table)
+	full.JoinOn(resourceTable, "r", Cond(full.Column("id"), "=", resourceTable.GetColumn("id").Of("r")), "full outer").Limit(5).Build()
+	if full.err != nil {
+		t.Fatal(full.err)
+	}
+	if !strings.HasPrefix(full.Query, "(SELECT") ||
+		!strings.Contains(full.Query, "LEFT JOIN test.resource AS r ON category.id = r.id)\nUNION ALL\n(SELECT") ||
+		!strings.Contains(full.Query, "RIGHT JOIN test.resource AS r ON category.id = r.id\nWHERE category.id IS NULL)\nLIMIT 5;") {
+		t.Fatalf("expected the full join to be the left join and the unmatched rows of the right join, got %s", full.Query)
+	}
+	if total := full.totalRowsQuery(nil); !strings.HasSuffix(total, "AS set_rows") {
+		t.Fatalf("expected total rows to count the union, got %s", total)
+	}
+	if grouped := full.Clone().GroupBy(full.Column("name")).Build(); !errors.Is(grouped.err, ErrInvalidJoin) {
+		t.Fatalf("expected grouped full joins to be rejected, got %v", grouped.err)
+	}
+	grouped := QueryTable[Category](table).
+		JoinOn(resourceTable, "r", Cond(full.Column("id"), "=", resourceTable.GetColumn("id").Of("r")), "full").
+		GroupBy(full.Column("name"))
+	if _, err := grouped.Run(context.Background(), NewMockDB()); !errors.Is(err, ErrInvalidJoin) {
+		t.Fatalf("expected Run to return the build error, got %v", err)
+	}
+	if _, err := SelectQuery[Category, Category](context.Background(), NewMockDB(), grouped, nil); !errors.Is(err, ErrInvalidJoin) {
+		t.Fatalf("expected SelectQuery to return the build error, got %v", err)
+	}
+
+	prefixed := QueryTable[Category](table).JoinOn(resourceTable.Prefix("tmp_"), "r", nil, "cross")
+	if tags := prefixed.cacheTags(); len(tags) != 1 || tags[0] != "test.resourcetmp_" {
+		t.Fatalf("expected the prefix of the joined table in its tag, got %v", tags)
+	}
+
+	on := Cond(q.Column("parent_id"), "=", q.Column("id").Of("parent"))
+	for name, failed := range map[string]*Query[Category]{
+		"self join without alias": QueryTable[Category](table).JoinOn(table, "", on, "inner"),
+		"unknown join type":       QueryTable[Category](table).JoinOn(table, "parent", on, "sideways"),
+		"cross join with on":      QueryTable[Category](table).JoinOn(table, "parent", on, "cross"),
+		"missing on":              QueryTable[Category](table).JoinOn(table, "parent", nil, "left"),
+		"duplicate alias":         QueryTable[Category](table).JoinOn(table, "parent", on, "left").JoinOn(resourceTable, "parent", nil, "cross"),
+		"second full join":        QueryTable[Category](table).JoinOn(table, "parent", on, "full").JoinOn(resourceTable, "r", Cond(q.Column("id"), "=", resourceTable.GetColumn("id").Of("r")), "full"),
+	} {
+		if !errors.Is(failed.err, ErrInvalidJoin) {
+			t.Fatalf("expected %s to be rejected, got %v", name, failed.err)
+		}
+	}
+}
+
+func TestQuery_JoinOrder(t *testing.T) {
+	table, err := NewTable[Log]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditTable, err := NewTable[AuditLog]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := func() string {
+		return QueryTable[Log](table).Select(table.GetColumn("id")).Join(auditTable.Columns, "left").Build().Query
+	}
+	first := build()
+	for i := 0; i < 20; i++ {
+		if query := build(); query != first {
+			t.Fatalf("expected joins to render in the same order, got %s and %s", first, query)
+		}
+	}
+}
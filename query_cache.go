@@ -0,0 +1,314 @@
+package QueryHelper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Seann-Moser/ctx_cache"
+	"golang.org/x/sync/singleflight"
+)
+
+// cacheTags returns the cache groups of every other table the query reads from, joins, where
+// columns and from subqueries included. Writes invalidate the group of their own table, so
+// adding the cached key to these groups lets a write to any referenced table expire it.
+func (q *Query[T]) cacheTags() []string {
+	own := q.FromTable.FullTableName() + q.tmpPrefix
+	tags := map[string]struct{}{}
+	add := func(c Column) {
+		if c.Table == "" {
+			return
+		}
+		if tag := q.columnTableName(c); tag != own {
+			tags[tag] = struct{}{}
+		}
+	}
+	for _, join := range q.JoinStmt {
+		for _, c := range join.Columns {
+			add(c)
+		}
+		if join.table != nil && join.table.cacheGroup() != own {
+			tags[join.table.cacheGroup()] = struct{}{}
+		}
+	}
+	addSubquery := func(sub Subquery) {
+		for _, tag := range sub.subqueryTags() {
+			if tag != own {
+				tags[tag] = struct{}{}
+			}
+		}
+	}
+	conditions := append(q.WhereStmts[:len(q.WhereStmts):len(q.WhereStmts)], q.HavingStmts...)
+	for _, expr := range q.WhereExprs {
+		conditions = append(conditions[:len(conditions):len(conditions)], expr.conditions()...)
+	}
+	for _, join := range q.JoinStmt {
+		if !isNilExpr(join.on) {
+			conditions = append(conditions[:len(conditions):len(conditions)], join.on.conditions()...)
+		}
+	}
+	for _, where := range conditions {
+		add(where.LeftValue)
+		if sub, ok := where.RightValue.(Subquery); ok {
+			addSubquery(sub)
+		}
+	}
+	for _, s := range q.selectSubqueries {
+		addSubquery(s.sub)
+	}
+	for _, s := range q.setOperations {
+		addSubquery(s.sub)
+	}
+	for _, cte := range q.ctes {
+		addSubquery(cte.sub)
+	}
+	if q.FromQuery != nil {
+		if tag := q.FromQuery.FromTable.FullTableName(); tag != own {
+			tags[tag] = struct{}{}
+		}
+		for _, tag := range q.FromQuery.cacheTags() {
+			if tag != own {
+				tags[tag] = struct{}{}
+			}
+		}
+	}
+	output := make([]string, 0, len(tags))
+	for tag := range tags {
+		output = append(output, tag)
+	}
+	sort.Strings(output)
+	return output
+}
+
+// columnTableName returns the table name of the column the same way Table.FullTableName does. Columns
+// taken from Table.Columns hold the raw dataset while Table.GetColumns applies the db prefix.
+func (q *Query[T]) columnTableName(c Column) string {
+	if q.FromTable.db == nil {
+		return c.FullTableName()
+	}
+	prefix := q.FromTable.db.GetDataset("")
+	if prefix != "" && strings.HasPrefix(c.Dataset, prefix) {
+		return c.FullTableName()
+	}
+	return fmt.Sprintf("%s.%s", q.FromTable.db.GetDataset(c.Dataset), c.Table)
+}
+
+// tagCacheKey adds the stored cache key to the groups of the other referenced tables
+func tagCacheKey(ctx context.Context, tags []string, key string) {
+	for _, tag := range tags {
+		_ = ctx_cache.GlobalCacheMonitor.UpdateCache(ctx, tag, key)
+	}
+}
+
+var queryFlight singleflight.Group
+
+// cacheEntry wraps cached query results with the time they were loaded, so expired
+// results can still be served while they are refreshed
+type cacheEntry[V any] struct {
+	Value    V         `json:"value"`
+	StoredAt time.Time `json:"stored_at"`
+	Empty    bool      `json:"empty"`
+}
+
+type cacheOptions struct {
+	duration time.Duration
+	stale    time.Duration
+	negative time.Duration
+	refresh  bool
+	tags     []string
+}
+
+func (q *Query[T]) cacheOptions() cacheOptions {
+	return cacheOptions{
+		duration: q.CacheDuration,
+		stale:    q.staleDuration,
+		negative: q.negativeCacheDuration,
+		refresh:  q.refreshCache,
+		tags:     q.cacheTags(),
+	}
+}
+
+// cachedFetch reads the value from the cache, calling fetch at most once at a time per cache key.
+// Expired values within the stale window are returned while a single background fetch refreshes them.
+// Empty values are only cached when a negative cache duration is set.
+func cachedFetch[V any](ctx context.Context, options cacheOptions, group, key string, isEmpty func(V) bool, fetch func(ctx context.Context) (V, error)) (V, error) {
+	flightKey := ctx_cache.GetKey[cacheEntry[V]](group, key)
+	load := func(ctx context.Context) (V, error) {
+		value, err := fetch(ctx)
+		if err != nil {
+			return value, err
+		}
+		empty := isEmpty(value)
+		if empty && options.negative <= 0 {
+			return value, nil
+		}
+		expiration := options.duration
+		if empty {
+			expiration = options.negative
+		} else if expiration > 0 {
+			expiration += options.stale
+		}
+		entry := cacheEntry[V]{Value: value, StoredAt: time.Now(), Empty: empty}
+		if err := ctx_cache.SetWithExpiration[cacheEntry[V]](ctx, expiration, group, key, entry); err == nil {
+			tagCacheKey(ctx, options.tags, flightKey)
+		}
+		return value, nil
+	}
+	flight := func(ctx context.Context) (V, error) {
+		value, err, shared := queryFlight.Do(flightKey, func() (interface{}, error) {
+			return load(ctx)
+		})
+		if err != nil {
+			var empty V
+			return empty, err
+		}
+		if shared {
+			// every caller gets its own copy, so changing the returned rows can not affect the other callers
+			return copyValue(value.(V))
+		}
+		return value.(V), nil
+	}
+	// the fetch is shared with other callers, so it must not be cancelled by this caller
+	shared := context.WithoutCancel(ctx)
+
+	if !options.refresh {
+		if entry, err := ctx_cache.Get[cacheEntry[V]](ctx, group, key); err == nil && entry != nil {
+			ttl := options.duration
+			if entry.Empty {
+				ttl = options.negative
+			}
+			age := time.Since(entry.StoredAt)
+			if ttl <= 0 || age < ttl {
+				return entry.Value, nil
+			}
+			if !entry.Empty && age < ttl+options.stale {
+				go func() {
+					_, _ = flight(shared)
+				}()
+				return entry.Value, nil
+			}
+		}
+	}
+	return flight(shared)
+}
+
+// copyValue deep copies the value through json, the same way cached values are stored
+func copyValue[V any](value V) (V, error) {
+	var copied V
+	b, err := json.Marshal(value)
+	if err != nil {
+		return copied, err
+	}
+	err = json.Unmarshal(b, &copied)
+	return copied, err
+}
+
+// cacheKeyEncoder writes named, length prefixed fields so adjacent values can not run together
+type cacheKeyEncoder struct {
+	builder strings.Builder
+}
+
+func (e *cacheKeyEncoder) field(name string, values ...string) {
+	e.builder.WriteString(name)
+	e.builder.WriteByte('=')
+	for _, v := range values {
+		e.builder.WriteString(strconv.Itoa(len(v)))
+		e.builder.WriteByte(':')
+		e.builder.WriteString(v)
+	}
+	e.builder.WriteByte(';')
+}
+
+func (e *cacheKeyEncoder) String() string {
+	return e.builder.String()
+}
+
+// cacheKeyString is the canonical encoding of everything that changes the sql text or the bound
+// arguments of the query. Arguments are sorted by name so equal queries always produce the same key.
+func (q *Query[T]) cacheKeyString(args ...interface{}) string {
+	e := &cacheKeyEncoder{}
+	isGroupBy := len(q.GroupByStmt) > 0
+	e.field("from", q.FromTable.FullTableName())
+	if q.FromQuery != nil {
+		e.field("from_query", q.FromQuery.cacheKeyString())
+	}
+	if q.fromWith != "" {
+		e.field("from_with", q.fromWith)
+	}
+	with, _ := q.renderWith()
+	if with != "" {
+		e.field("with", with)
+	}
+	e.field("lock", strconv.FormatBool(q.NoLock), strconv.FormatBool(q.ReadPast))
+	for _, c := range q.SelectColumns {
+		e.field("select", c.FullName(isGroupBy, true))
+	}
+	subqueryColumns, _ := q.renderSelectSubqueries()
+	for _, c := range subqueryColumns {
+		e.field("select_subquery", c)
+	}
+	if q.distinct {
+		e.field("select_distinct")
+	}
+	for _, c := range q.DistinctSelectColumns {
+		e.field("distinct", c.FullName(isGroupBy, true))
+	}
+	for i, join := range q.JoinStmt {
+		if join.table != nil {
+			clause, _ := q.renderJoin(i, join)
+			e.field("join_on", clause)
+			continue
+		}
+		columns := make([]string, 0, len(join.Columns))
+		for _, c := range join.Columns {
+			columns = append(columns, c.FullName(false, false))
+		}
+		sort.Strings(columns)
+		e.field("join", append([]string{strings.ToUpper(join.JoinType)}, columns...)...)
+	}
+	for _, w := range q.WhereStmts {
+		e.field("where", w.ToString(), strconv.Itoa(w.Level), strings.ToUpper(w.JoinOperator))
+	}
+	exprs, _ := q.renderWhereExprs()
+	for _, expr := range exprs {
+		e.field("where_expr", expr)
+	}
+	for _, c := range q.GroupByStmt {
+		e.field("group_by", groupByName(c))
+	}
+	having, _ := q.renderHaving()
+	for _, h := range having {
+		e.field("having", h)
+	}
+	setQueries, _ := q.renderSetOperations()
+	for _, s := range setQueries {
+		e.field("set_operation", s)
+	}
+	for _, c := range q.OrderByStmt {
+		e.field("order_by", c.GetOrderStmt(isGroupBy))
+	}
+	e.field("limit", strconv.Itoa(q.LimitCount), strconv.Itoa(q.Pagination.Limit), strconv.Itoa(q.Pagination.Offset))
+
+	argsData := q.Args(args...)
+	names := make([]string, 0, len(argsData))
+	for k := range argsData {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		e.field("arg", k, canonicalValue(argsData[k]))
+	}
+	return e.String()
+}
+
+// canonicalValue encodes argument values as json, which sorts map keys and keeps float precision
+func canonicalValue(v interface{}) string {
+	if b, err := json.Marshal(v); err == nil {
+		return string(b)
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -1,10 +1,13 @@
 package QueryHelper
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
 
+var ErrUnknownOperator = errors.New("unknown where operator")
+
 type WhereStmt struct {
 	LeftValue    Column
 	Conditional  string
@@ -13,6 +16,11 @@ type WhereStmt struct {
 	JoinOperator string
 	Index        int
 	Flip         bool
+
+	// escaped like patterns use likeEscape to escape wildcards
+	escaped bool
+	// left replaces the rendered column, used by having statements to reference aggregates
+	left string
 }
 
 func NewWhere(column Column, value interface{}) *WhereStmt {
@@ -37,56 +45,176 @@ func (w *WhereStmt) GetArg() (string, interface{}) {
 }
 
 func (w *WhereStmt) ToString() string {
-	column := w.LeftValue
-	if column.Name == "" {
-		return ""
+	formatted, _, _ := w.bind(w.paramName())
+	return formatted
+}
+
+func (w *WhereStmt) paramName() string {
+	if w.LeftValue.Name == "" {
+		// exists statements have no column, their index keeps the parameters of their subqueries apart
+		if w.Index > 0 {
+			return fmt.Sprintf("exists_%d", w.Index)
+		}
+		return "exists"
 	}
-	tmp := column.Where
-	if w.Conditional != "" {
-		tmp = w.Conditional
-	} else if tmp == "" {
-		tmp = "="
+	name := w.LeftValue.Name
+	if w.LeftValue.alias != "" {
+		// columns of a joined table can share their name with columns of the table
+		name = w.LeftValue.alias + "_" + name
 	}
-	var suffix string
 	if w.Index > 0 {
-		suffix = fmt.Sprintf("_%d", w.Index)
+		return fmt.Sprintf("%s_%d", name, w.Index)
 	}
+	return name
+}
 
-	var formatted string
-	switch strings.TrimSpace(strings.ToLower(tmp)) {
-	case "is not":
-		if w.RightValue == nil {
-			formatted = fmt.Sprintf("%s %s null", column.FullName(false, false), tmp)
+// conditional returns the operator as written and normalized to lower case with single spaces
+func (w *WhereStmt) conditional() (string, string) {
+	written := w.LeftValue.Where
+	if w.Conditional != "" {
+		written = w.Conditional
+	}
+	written = strings.TrimSpace(written)
+	if written == "" {
+		written = "="
+	}
+	return written, strings.Join(strings.Fields(strings.ToLower(written)), " ")
+}
+
+// bind renders the statement with its values bound to parameters named after param, it returns the values of the parameters
+func (w *WhereStmt) bind(param string) (string, map[string]interface{}, error) {
+	written, conditional := w.conditional()
+	args := map[string]interface{}{}
+	switch conditional {
+	case "exists", "not exists":
+		sub, ok := w.RightValue.(Subquery)
+		if !ok || sub == nil {
+			return "", nil, fmt.Errorf("%w: %s requires a subquery", ErrInvalidCondition, conditional)
+		}
+		query, subArgs, err := renamedSubquery(sub, param+"_")
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("%s (%s)", strings.ToUpper(conditional), query), subArgs, nil
+	}
+
+	if w.LeftValue.Name == "" {
+		return "", nil, fmt.Errorf("%w: missing column", ErrInvalidCondition)
+	}
+	column := w.LeftValue.FullName(false, false)
+	if w.left != "" {
+		column = w.left
+	}
+	if sub, ok := w.RightValue.(Subquery); ok {
+		return w.bindSubquery(column, written, conditional, param, sub)
+	}
+	if other, ok := w.RightValue.(Column); ok {
+		return w.bindColumn(column, written, conditional, other)
+	}
+	switch conditional {
+	case "is null", "is not null":
+		return fmt.Sprintf("%s %s", column, strings.ToUpper(conditional)), args, nil
+	case "is", "is not":
+		switch v := w.RightValue.(type) {
+		case nil:
+			return fmt.Sprintf("%s %s null", column, written), args, nil
+		case bool:
+			return fmt.Sprintf("%s %s %t", column, written, v), args, nil
+		}
+		return "", nil, fmt.Errorf("%w: %s %s only accepts nil or a bool, got %T", ErrInvalidCondition, w.LeftValue.Name, conditional, w.RightValue)
+	case "in", "not in":
+		args[param] = w.RightValue
+		return fmt.Sprintf("%s %s (:%s)", column, written, param), args, nil
+	case "between", "not between":
+		bounds, ok := w.RightValue.(Range)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %s %s requires a Range, got %T", ErrInvalidCondition, w.LeftValue.Name, conditional, w.RightValue)
+		}
+		args[param+"_from"] = bounds.From
+		args[param+"_to"] = bounds.To
+		return fmt.Sprintf("%s %s :%s_from AND :%s_to", column, strings.ToUpper(conditional), param, param), args, nil
+	case "like", "not like", "ilike", "not ilike":
+		args[param] = w.RightValue
+		operator := "LIKE"
+		if strings.HasPrefix(conditional, "not") {
+			operator = "NOT LIKE"
 		}
-	case "is":
-		if w.RightValue == nil {
-			formatted = fmt.Sprintf("%s %s null", column.FullName(false, false), tmp)
+		value := ":" + param
+		if strings.HasSuffix(conditional, "ilike") {
+			column = fmt.Sprintf("LOWER(%s)", column)
+			value = fmt.Sprintf("LOWER(%s)", value)
 		}
-	case "not in":
-		fallthrough
-	case "in":
-		formatted = fmt.Sprintf("%s %s (:%s%s)", column.FullName(false, false), tmp, column.Name, suffix)
-	default:
+		formatted := fmt.Sprintf("%s %s %s", column, operator, value)
+		if w.escaped {
+			formatted += fmt.Sprintf(" ESCAPE '%c'", likeEscape)
+		}
+		return formatted, args, nil
+	case "is distinct from", "is not distinct from":
+		args[param] = w.RightValue
+		if conditional == "is not distinct from" {
+			return fmt.Sprintf("%s <=> :%s", column, param), args, nil
+		}
+		return fmt.Sprintf("NOT (%s <=> :%s)", column, param), args, nil
+	case "=", "!=", "<>", "<", "<=", ">", ">=", "<=>":
+		args[param] = w.RightValue
 		if w.Flip {
-			formatted = fmt.Sprintf(":%s%s %s %s", column.Name, suffix, tmp, column.FullName(false, false))
-		} else {
-			formatted = fmt.Sprintf("%s %s :%s%s", column.FullName(false, false), tmp, column.Name, suffix)
+			return fmt.Sprintf(":%s %s %s", param, written, column), args, nil
 		}
+		return fmt.Sprintf("%s %s :%s", column, written, param), args, nil
+	}
+	return "", nil, fmt.Errorf("%w: %s", ErrUnknownOperator, conditional)
+}
 
+// bindColumn renders comparisons between two columns, such as the conditions of a join
+func (w *WhereStmt) bindColumn(column, written, conditional string, other Column) (string, map[string]interface{}, error) {
+	if other.Name == "" {
+		return "", nil, fmt.Errorf("%w: missing column", ErrInvalidCondition)
 	}
-	if strings.Contains(formatted, ":") {
-		return formatted
+	otherColumn := other.FullName(false, false)
+	switch conditional {
+	case "is distinct from":
+		return fmt.Sprintf("NOT (%s <=> %s)", column, otherColumn), map[string]interface{}{}, nil
+	case "is not distinct from":
+		return fmt.Sprintf("%s <=> %s", column, otherColumn), map[string]interface{}{}, nil
+	case "=", "!=", "<>", "<", "<=", ">", ">=", "<=>":
+		if w.Flip {
+			return fmt.Sprintf("%s %s %s", otherColumn, written, column), map[string]interface{}{}, nil
+		}
+		return fmt.Sprintf("%s %s %s", column, written, otherColumn), map[string]interface{}{}, nil
 	}
-	return ""
+	return "", nil, fmt.Errorf("%w: %s does not accept a column", ErrInvalidCondition, conditional)
+}
+
+// bindSubquery renders in conditions and comparisons against a subquery, its parameters are prefixed with param
+func (w *WhereStmt) bindSubquery(column, written, conditional, param string, sub Subquery) (string, map[string]interface{}, error) {
+	query, args, err := renamedSubquery(sub, param+"_")
+	if err != nil {
+		return "", nil, err
+	}
+	switch conditional {
+	case "in", "not in":
+		return fmt.Sprintf("%s %s (%s)", column, written, query), args, nil
+	case "=", "!=", "<>", "<", "<=", ">", ">=", "<=>":
+		if w.Flip {
+			return fmt.Sprintf("(%s) %s %s", query, written, column), args, nil
+		}
+		return fmt.Sprintf("%s %s (%s)", column, written, query), args, nil
+	}
+	return "", nil, fmt.Errorf("%w: %s does not accept a subquery", ErrInvalidCondition, conditional)
 }
 
 func generateWhere(whereStatements []*WhereStmt) string {
+	return "WHERE " + whereConditions(whereStatements)
+}
+
+// whereConditions joins the level based statements, statements that do not render are skipped along with their join operator
+func whereConditions(whereStatements []*WhereStmt) string {
 	var builder strings.Builder
-	builder.WriteString("WHERE ")
 
 	previousLevel := 0
+	written := false
 
-	for i, w := range whereStatements {
+	for _, w := range whereStatements {
 		if where := w.ToString(); where != "" {
 			// Handle level changes
 			if w.Level < previousLevel {
@@ -95,12 +223,13 @@ func generateWhere(whereStatements []*WhereStmt) string {
 				}
 			}
 
-			if i > 0 {
-				if w.JoinOperator == "" {
-					w.JoinOperator = "AND"
+			if written {
+				joinOperator := w.JoinOperator
+				if joinOperator == "" {
+					joinOperator = "AND"
 				}
 				builder.WriteString(" ")
-				builder.WriteString(strings.ToUpper(w.JoinOperator))
+				builder.WriteString(strings.ToUpper(joinOperator))
 			}
 
 			if w.Level > previousLevel {
@@ -112,6 +241,7 @@ func generateWhere(whereStatements []*WhereStmt) string {
 			builder.WriteString(" ")
 			builder.WriteString(where)
 			previousLevel = w.Level
+			written = true
 		}
 	}
 
@@ -0,0 +1,81 @@
+package QueryHelper
+
+import (
+	"fmt"
+	"strings"
+)
+
+type setOperation struct {
+	operator string
+	sub      Subquery
+}
+
+// Union combines the rows of q with the rows of the queries without duplicates. Every query must select the same
+// columns in the same order, the order by and limit of q apply to the combined rows.
+func (q *Query[T]) Union(queries ...Subquery) *Query[T] {
+	return q.addSetOperation("UNION", queries...)
+}
+
+// UnionAll is a Union that keeps duplicate rows
+func (q *Query[T]) UnionAll(queries ...Subquery) *Query[T] {
+	return q.addSetOperation("UNION ALL", queries...)
+}
+
+// Intersect keeps the rows of q that are returned by the queries
+func (q *Query[T]) Intersect(queries ...Subquery) *Query[T] {
+	return q.addSetOperation("INTERSECT", queries...)
+}
+
+// Except keeps the rows of q that are not returned by the queries
+func (q *Query[T]) Except(queries ...Subquery) *Query[T] {
+	return q.addSetOperation("EXCEPT", queries...)
+}
+
+func (q *Query[T]) addSetOperation(operator string, queries ...Subquery) *Query[T] {
+	q = q.edit()
+	for _, sub := range queries {
+		if _, _, err := renamedSubquery(sub, ""); err != nil {
+			if q.err == nil {
+				q.err = err
+			}
+			return q
+		}
+		q.cansave = false
+		q.setOperations = append(q.setOperations, setOperation{operator: operator, sub: sub})
+	}
+	return q
+}
+
+// renderSetOperations returns the combined queries with their operator and the values of their parameters, the
+// parameters of every query are prefixed with its position so they can not collide
+func (q *Query[T]) renderSetOperations() ([]string, map[string]interface{}) {
+	var queries []string
+	args := map[string]interface{}{}
+	for i, s := range q.setOperations {
+		// queries are checked when they are added
+		query, subArgs, err := renamedSubquery(s.sub, fmt.Sprintf("set_%d_", i))
+		if err != nil {
+			continue
+		}
+		queries = append(queries, fmt.Sprintf("%s\n(%s)", s.operator, query))
+		for k, v := range subArgs {
+			args[k] = v
+		}
+	}
+	return queries, args
+}
+
+// setStatement renders the select statement combined with the set operations, without the shared order and limit
+func (q *Query[T]) setStatement() string {
+	queries, _ := q.renderSetOperations()
+	statement := q.selectStatement()
+	if q.hasFullJoin() {
+		statement = q.fullJoinStatement()
+	} else if len(queries) > 0 {
+		statement = "(" + statement + ")"
+	}
+	if len(queries) == 0 {
+		return statement
+	}
+	return fmt.Sprintf("%s\n%s", statement, strings.Join(queries, "\n"))
+}
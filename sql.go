@@ -17,28 +17,43 @@ import (
 )
 
 var _ DB = &SqlDB{}
+var _ PreparedDB = &SqlDB{}
 
 type SqlDB struct {
 	sql           *sqlx.DB
 	updateColumns bool
 	tablePrefix   string
+	statements    *StatementCache
 }
 
 func Flags() *pflag.FlagSet {
 	fs := pflag.NewFlagSet("sql-db", pflag.ExitOnError)
 	fs.Bool("sql-db-update-columns", false, "")
 	fs.String("sql-db-prefix", "", "")
+	fs.Int64("sql-db-snowflake-node", 0, "node id used when generating snowflake ids, unique per instance")
+	fs.Int("sql-db-statement-cache-size", DefaultStatementCacheSize, "max prepared statements kept per connection pool")
 	return fs
 }
 
 func NewSql(db *sqlx.DB) *SqlDB {
+	// a node set with SetSnowflakeNode is kept unless the flag is set
+	if viper.IsSet("sql-db-snowflake-node") {
+		if err := SetSnowflakeNode(viper.GetInt64("sql-db-snowflake-node")); err != nil {
+			ctxLogger.Warn(context.Background(), "invalid snowflake node", zap.Error(err))
+		}
+	}
 	return &SqlDB{
 		sql:           db,
 		updateColumns: viper.GetBool("sql-db-update-columns"),
 		tablePrefix:   viper.GetString("sql-db-prefix"),
+		statements:    NewStatementCache(viper.GetInt("sql-db-statement-cache-size")),
 	}
 }
 
+func (s *SqlDB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error) {
+	return s.sql.BeginTxx(ctx, opts)
+}
+
 func (s *SqlDB) Ping(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
@@ -125,6 +140,9 @@ func (s *SqlDB) CreateTable(ctx context.Context, dataset, table string, columns
 		}
 	}
 
+	// prepared statements may reference the old table definition
+	defer s.statements.Invalidate()
+
 	// Optionally update columns
 	if s.updateColumns {
 		return s.ColumnUpdater(ctx, dataset, table, columns)
@@ -168,6 +186,21 @@ func (s *SqlDB) QueryContext(ctx context.Context, query string, options *DBOptio
 	return rows, nil
 }
 
+// PreparedQueryContext runs the query with a prepared statement kept for the query name, reads with NoLock or
+// ReadPast fall back to QueryContext since they run in their own transaction
+func (s *SqlDB) PreparedQueryContext(ctx context.Context, name, query string, options *DBOptions, args interface{}) (DBRow, error) {
+	if name == "" || s.statements == nil || (options != nil && (options.NoLock || options.ReadPast)) {
+		return s.QueryContext(ctx, query, options, args)
+	}
+	stmt, release, err := s.statements.Prepare(ctx, s.sql, name, QueryTypeSQL, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed preparing query %s: %w", name, err)
+	}
+	// open rows keep the statement alive after it is released
+	defer release()
+	return stmt.QueryxContext(ctx, args)
+}
+
 func (s *SqlDB) RawQueryContext(ctx context.Context, query string, options *DBOptions, args ...interface{}) (DBRow, error) {
 	defer func() { //catch or finally
 		if err := recover(); err != nil { //catch
@@ -201,7 +234,7 @@ func (s *SqlDB) RawQueryContext(ctx context.Context, query string, options *DBOp
 	return rows, nil
 }
 
-func (s *SqlDB) ExecContext(ctx context.Context, query string, args interface{}) error {
+func (s *SqlDB) ExecContext(ctx context.Context, query string, args interface{}) (sql.Result, error) {
 	defer func() { //catch or finally
 		if err := recover(); err != nil { //catch
 			fmt.Fprintf(os.Stderr, "Exception: %v\n", err)
@@ -210,19 +243,19 @@ func (s *SqlDB) ExecContext(ctx context.Context, query string, args interface{})
 
 	tx, err := s.sql.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("error starting transaction: %w", err)
+		return nil, fmt.Errorf("error starting transaction: %w", err)
 	}
-	_, err = tx.NamedExecContext(ctx, query, args)
+	result, err := tx.NamedExecContext(ctx, query, args)
 	if err != nil {
 		ctxLogger.Warn(ctx, "rolled back transaction", zap.String("query", query), zap.Any("args", args), zap.Error(err))
 		_ = tx.Rollback()
-		return err
+		return nil, err
 	}
 	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("error committing query: %w", err)
+		return nil, fmt.Errorf("error committing query: %w", err)
 	}
 
-	return nil
+	return result, nil
 }
 
 func (s *SqlDB) ColumnUpdater(ctx context.Context, dataset, table string, columns map[string]Column) error {
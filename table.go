@@ -2,13 +2,9 @@ package QueryHelper
 
 import (
 	"context"
-	"crypto/sha1"
 	"database/sql"
-	"encoding/base64"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/Seann-Moser/ctx_cache"
 	"github.com/Seann-Moser/go-serve/pkg/ctxLogger"
 	"github.com/xwb1989/sqlparser"
 	"go.opentelemetry.io/otel"
@@ -16,10 +12,9 @@ import (
 	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/google/uuid"
-
 	"github.com/jmoiron/sqlx"
 )
 
@@ -29,6 +24,15 @@ const (
 	QueryTypeSQL      = "sql"
 	QueryTypeFireBase = "firebase"
 )
+
+type Operation string
+
+const (
+	OperationInsert Operation = "insert"
+	OperationUpsert Operation = "upsert"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
 const (
 	TagConfigPrefix     = "qc"
 	TagColumnNamePrefix = "db"
@@ -49,8 +53,12 @@ type Table[T any] struct {
 	QueryType QueryType         `json:"query_type"`
 	db        DB
 
-	tmpPrefix string
-	useNoLock bool
+	tmpPrefix    string
+	useNoLock    bool
+	errOnNoRows  bool
+	options      TableOptions
+	historyTable *Table[HistoryRow]
+	outboxTable  *Table[OutboxEvent]
 }
 
 func NewTable[T any](databaseName string, queryType QueryType) (*Table[T], error) {
@@ -62,6 +70,9 @@ func NewTable[T any](databaseName string, queryType QueryType) (*Table[T], error
 		Columns:   map[string]Column{},
 		QueryType: queryType,
 	}
+	if o, ok := any(s).(TableOptioner); ok {
+		newTable.options = o.TableOptions()
+	}
 
 	structType := reflect.TypeOf(s)
 	var setPrimary bool
@@ -87,6 +98,9 @@ func NewTable[T any](databaseName string, queryType QueryType) (*Table[T], error
 				field.Tag.Get(TagConfigPrefix),
 				err)
 		}
+		if column.AutoGenerateIDType != "" && !hasIDGenerator(column.AutoGenerateIDType) {
+			return nil, fmt.Errorf("%w %q for column %s", ErrUnknownIDGenerator, column.AutoGenerateIDType, column.Name)
+		}
 		column.ColumnOrder = i
 		column.Table = newTable.Name
 		column.Dataset = databaseName
@@ -183,7 +197,12 @@ func (t *Table[T]) InitializeTable(ctx context.Context, db DB, suffix ...string)
 	if err != nil {
 		return err
 	}
-	return nil
+	// saved named queries may select columns of the previous table definition
+	InvalidatePreparedQueries()
+	if err := t.initializeHistory(ctx, db); err != nil {
+		return err
+	}
+	return t.initializeOutbox(ctx, db)
 }
 func (t *Table[T]) GetDef() ([]ColumnInfo, error) {
 	return t.db.GetTableDefinition(t.Dataset, t.Name)
@@ -261,13 +280,23 @@ func (t *Table[T]) Select(ctx context.Context, db DB, conditional string, groupB
 }
 
 func (t *Table[T]) NamedSelect(ctx context.Context, db DB, query string, args ...interface{}) ([]*T, error) {
+	a, err := combineStructs(args...)
+	if err != nil {
+		return nil, err
+	}
+	return t.selectArgs(ctx, db, "", query, a)
+}
+
+// selectArgs runs the query with the args as they are, combineStructs passes them through json which turns
+// integers into float64 and loses the precision of values above 2^53. Queries with a name reuse a prepared statement.
+func (t *Table[T]) selectArgs(ctx context.Context, db DB, name, query string, args map[string]interface{}) ([]*T, error) {
 	if db == nil {
 		db = t.db
 	}
 	if db == nil {
 		return nil, nil
 	}
-	rows, err := t.NamedQuery(ctx, db, query, args...)
+	rows, err := queryArgs(ctx, db, name, query, &DBOptions{NoLock: t.useNoLock}, args)
 	if err != nil {
 		return nil, err
 	}
@@ -281,6 +310,9 @@ func (t *Table[T]) NamedSelect(ctx context.Context, db DB, query string, args ..
 		if err != nil {
 			return nil, err
 		}
+		if err := runHook(ctx, hookAfterFind, &tmp); err != nil {
+			return nil, err
+		}
 		output = append(output, &tmp)
 	}
 	return output, nil
@@ -294,6 +326,10 @@ func (t *Table[T]) GetSelectableColumns(groupBy bool, names ...Column) []string
 			if name.Name == "" {
 				continue
 			}
+			if name.window != nil || name.derived || name.alias != "" {
+				selectValues = append(selectValues, name.FullName(groupBy, true))
+				continue
+			}
 			e, found := selectableColumns[name.Name]
 			if !found {
 				continue
@@ -384,6 +420,35 @@ func (t Table[T]) UseNoLock() *Table[T] {
 	return &t
 }
 
+// ErrOnNoRows makes Update and Delete return sql.ErrNoRows when no row was affected
+func (t Table[T]) ErrOnNoRows() *Table[T] {
+	t.errOnNoRows = true
+	return &t
+}
+
+func (t *Table[T]) checkRowsAffected(result sql.Result) error {
+	if !t.errOnNoRows || result == nil {
+		return nil
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// firstGeneratedID returns the id of the first auto generated column for the first row
+func (t *Table[T]) firstGeneratedID(rowIDs []map[string]string) string {
+	columns := t.GetGenerateID()
+	if len(columns) == 0 || len(rowIDs) == 0 {
+		return ""
+	}
+	return rowIDs[0][columns[0].Name]
+}
+
 func (t *Table[T]) IsAutoGenerateID() bool {
 	for _, e := range t.Columns {
 		if e.AutoGenerateID {
@@ -400,75 +465,97 @@ func (t *Table[T]) GetGenerateID() []Column {
 			output = append(output, e)
 		}
 	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].ColumnOrder < output[j].ColumnOrder
+	})
 	return output
 }
 
 func (t *Table[T]) GenerateID() map[string]string {
 	m := map[string]string{}
 	for _, e := range t.GetGenerateID() {
-		uid := uuid.New().String()
-		switch e.AutoGenerateIDType {
-		case "hex":
-			hasher := sha1.New()
-			hasher.Write([]byte(uid))
-			m[e.Name] = hex.EncodeToString(hasher.Sum(nil))
-		case "base64":
-			hasher := sha1.New()
-			hasher.Write([]byte(uid))
-			m[e.Name] = base64.URLEncoding.EncodeToString(hasher.Sum(nil))
-		case "uuid":
-			fallthrough
-		default:
-			m[e.Name] = uid
+		if e.IsAutoIncrement() {
+			continue
 		}
+		m[e.Name] = GetIDGenerator(e.AutoGenerateIDType)()
 	}
 	return m
 }
 
 func (t *Table[T]) GenerateIDs(amount int) map[string]interface{} {
 	m := map[string]interface{}{}
-	colGen := map[string]func() string{}
-
-	for _, e := range t.GetGenerateID() {
-		var genID func() string
-		switch e.AutoGenerateIDType {
-		case "hex":
-			genID = func() string {
-				hasher := sha1.New()
-				hasher.Write([]byte(uuid.New().String()))
-				m[e.Name] = hex.EncodeToString(hasher.Sum(nil))
-				return uuid.New().String()
-			}
-		case "base64":
-			genID = func() string {
-				hasher := sha1.New()
-				hasher.Write([]byte(uuid.New().String()))
-				m[e.Name] = base64.URLEncoding.EncodeToString(hasher.Sum(nil))
-				return uuid.New().String()
-			}
-		case "uuid":
-			fallthrough
-		default:
-			genID = func() string {
-				return uuid.New().String()
-			}
+	for i, ids := range t.GenerateRowIDs(amount) {
+		for k, v := range ids {
+			m[fmt.Sprintf("%d_%s", i, k)] = v
 		}
-		colGen[e.Name] = genID
 	}
+	return m
+}
 
+// GenerateRowIDs generates a distinct set of ids for every row, in row order
+func (t *Table[T]) GenerateRowIDs(amount int) []map[string]string {
+	rowIDs := make([]map[string]string, amount)
 	for i := 0; i < amount; i++ {
-		for k, v := range colGen {
-			m[fmt.Sprintf("%d_%s", i, k)] = v()
+		rowIDs[i] = t.GenerateID()
+	}
+	return rowIDs
+}
+
+// rowArgs combines the rows with their generated ids into a single map of prefixed named arguments
+func (t *Table[T]) rowArgs(rows ...T) (map[string]interface{}, []map[string]string, error) {
+	rowIDs := t.GenerateRowIDs(len(rows))
+	args := map[string]interface{}{}
+	for rowIndex, r := range rows {
+		tmpArgs, err := combineStructs(rowIDs[rowIndex], r)
+		if err != nil {
+			return nil, nil, err
+		}
+		tmpArgs = AddPrefix(fmt.Sprintf("%d_", rowIndex), tmpArgs)
+		args, err = combineMaps(args, tmpArgs)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
+	return args, rowIDs, nil
+}
 
-	return m
+// setAutoIncrementIDs fills the AUTO_INCREMENT columns of the first row from the last insert id. The ids of the other
+// rows of a multi row insert are not reported, they are not consecutive with innodb_autoinc_lock_mode=2, an
+// auto_increment_increment above 1 or rows that carry their own id. Insert the rows one at a time when every id is needed.
+func (t *Table[T]) setAutoIncrementIDs(result sql.Result, rowIDs []map[string]string) error {
+	var autoIncrement []Column
+	for _, c := range t.GetGenerateID() {
+		if c.IsAutoIncrement() {
+			autoIncrement = append(autoIncrement, c)
+		}
+	}
+	if len(autoIncrement) == 0 || result == nil {
+		return nil
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if len(rowIDs) == 0 {
+		return nil
+	}
+	for _, c := range autoIncrement {
+		rowIDs[0][c.Name] = strconv.FormatInt(id, 10)
+	}
+	return nil
 }
+
 func (t *Table[T]) InsertStatement(amount int) string {
+	return t.insertStatement(amount, false)
+}
+
+// insertStatement leaves the AUTO_INCREMENT columns out of the statement when omitAutoIncrement is set, so the db
+// generates them instead of receiving the zero value
+func (t *Table[T]) insertStatement(amount int, omitAutoIncrement bool) string {
 	var columnNames []string
 	var values []string
 	for _, e := range t.GetColumns() {
-		if e.Skip {
+		if e.Skip || (omitAutoIncrement && e.IsAutoIncrement()) {
 			continue
 		}
 		columnNames = append(columnNames, e.Name)
@@ -502,7 +589,12 @@ func (t *Table[T]) UpsertStatement(amount int) string {
 	var setValues []string
 
 	for _, e := range t.Columns {
-		if !e.Update {
+		if !e.Update && !e.UpdatedBy {
+			continue
+		}
+		if e.UpdatedBy && !e.Update {
+			// without an actor in the context the stored value is kept
+			setValues = append(setValues, fmt.Sprintf("%s = COALESCE(NULLIF(VALUES(%s), ''), %s)", e.Name, e.Name, e.Name))
 			continue
 		}
 		setValues = append(setValues, fmt.Sprintf("%s = VALUES(%s)", e.Name, e.Name))
@@ -519,7 +611,12 @@ func (t *Table[T]) UpdateStatement() string {
 		} else if e.AutoGenerateID {
 			whereValues = append(whereValues, fmt.Sprintf("%s = :%s", e.Name, e.Name))
 		}
-		if !e.Update {
+		if !e.Update && !e.UpdatedBy {
+			continue
+		}
+		if e.UpdatedBy && !e.Update {
+			// without an actor in the context the stored value is kept
+			setValues = append(setValues, fmt.Sprintf("%s = COALESCE(NULLIF(:%s, ''), %s)", e.Name, e.Name, e.Name))
 			continue
 		}
 		setValues = append(setValues, fmt.Sprintf("%s = :%s", e.Name, e.Name))
@@ -561,13 +658,25 @@ func (t *Table[T]) DeleteWithColumns(ctx context.Context, fullTableName string,
 	tracer := otel.GetTracerProvider()
 	ctx, span := tracer.Tracer("delete-w-column").Start(ctx, t.FullTableName())
 	defer span.End()
-	//tableUpdateSignal <- t.FullTableName()
+	if err := runHook(ctx, hookBeforeDelete, &s); err != nil {
+		return err
+	}
 
-	err := t.db.ExecContext(ctx, DeleteStatement(fullTableName, columns), s)
+	result, err := t.db.ExecContext(ctx, DeleteStatement(fullTableName, columns), s)
 	if err != nil {
 		return err
 	}
-	_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, t.FullTableName()+t.tmpPrefix)
+	InvalidateCacheGroup(ctx, t.cacheGroup())
+	if t.entityCacheEnabled() {
+		InvalidateCacheGroup(ctx, t.entityCacheGroup())
+	}
+	if err := t.checkRowsAffected(result); err != nil {
+		return err
+	}
+	t.publishChanges(OperationDelete, s)
+	if err := runHook(ctx, hookAfterDelete, &s); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -621,16 +730,16 @@ func (t *Table[T]) RawQuery(ctx context.Context, db DB, query string, args ...in
 	return db.RawQueryContext(ctx, query, &DBOptions{NoLock: t.useNoLock}, args...)
 }
 
-func (t *Table[T]) NamedExec(ctx context.Context, db DB, query string, args ...interface{}) error {
+func (t *Table[T]) NamedExec(ctx context.Context, db DB, query string, args ...interface{}) (sql.Result, error) {
 	if db == nil {
 		db = t.db
 	}
 	if db == nil {
-		return nil
+		return nil, nil
 	}
 	a, err := combineStructs(args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	query = fixArrays(query, a)
 	return db.ExecContext(ctx, query, a)
@@ -699,13 +808,22 @@ func (t *Table[T]) generateJoinStmt(columns map[string]Column, JoinType string)
 	case "right":
 		joinExp = "RIGHT JOIN"
 	}
+	// columns are visited in name order so the joins always render in the same order
+	keys := make([]string, 0, len(columns))
+	for k := range columns {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var tables []string
 	tableJoins := map[string][]string{}
-	for _, column := range columns {
+	for _, k := range keys {
+		column := columns[k]
 		name, found := t.HasColumn(column)
 		if !column.Join || !found {
 			continue
 		}
 		if _, found := tableJoins[column.FullTableName()]; !found {
+			tables = append(tables, column.FullTableName())
 			tableJoins[column.FullTableName()] = []string{}
 			joinStmt := fmt.Sprintf("%s %s ON %s.%s = %s.%s", joinExp, column.FullTableName(), column.Table, column.Name, t.Name, name)
 			tableJoins[column.FullTableName()] = append(tableJoins[column.FullTableName()], joinStmt)
@@ -716,8 +834,8 @@ func (t *Table[T]) generateJoinStmt(columns map[string]Column, JoinType string)
 	}
 
 	output := ""
-	for _, v := range tableJoins {
-		output += strings.Join(v, " AND ") + "\n"
+	for _, table := range tables {
+		output += strings.Join(tableJoins[table], " AND ") + "\n"
 	}
 	return output
 }
@@ -734,134 +852,159 @@ func (t *Table[T]) generateWhereStmt(columns map[string]Column) string {
 }
 
 func (t *Table[T]) Insert(ctx context.Context, db DB, s ...T) (string, error) {
+	rowIDs, err := t.InsertWithIDs(ctx, db, s...)
+	if err != nil {
+		return "", err
+	}
+	return t.firstGeneratedID(rowIDs), nil
+}
+
+// InsertWithIDs inserts the rows and returns the auto generated ids of every row, in row order.
+// AUTO_INCREMENT columns are only reported for the first row, see setAutoIncrementIDs.
+func (t *Table[T]) InsertWithIDs(ctx context.Context, db DB, s ...T) ([]map[string]string, error) {
+	return t.insertRows(ctx, db, append([]T(nil), s...))
+}
+
+// insertRows inserts the rows, writing the changes made by hooks and the generated ids back into rows
+func (t *Table[T]) insertRows(ctx context.Context, db DB, rows []T) ([]map[string]string, error) {
 	if db == nil {
 		db = t.db
 	}
 	if db == nil {
-		return "", nil
+		return nil, nil
 	}
 	tracer := otel.GetTracerProvider()
 	ctx, span := tracer.Tracer("insert").Start(ctx, t.FullTableName())
 	defer span.End()
+	if err := t.setAuditRows(ctx, true, rows); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	if err := runHooks(ctx, hookBeforeInsert, rows); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	var rowIDs []map[string]string
 	if t.IsAutoGenerateID() {
-		generateIds := t.GenerateID()
-		args := map[string]interface{}{}
-		for rowIndex, i := range s {
-			tmpArgs, err := combineStructs(generateIds, i)
-			if err != nil {
-				return "", err
-			}
-			tmpArgs = AddPrefix(fmt.Sprintf("%d_", rowIndex), tmpArgs)
-			args, err = combineMaps(args, tmpArgs)
-			if err != nil {
-				return "", err
+		var args map[string]interface{}
+		var err error
+		args, rowIDs, err = t.rowArgs(rows...)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		result, err := db.ExecContext(ctx, t.InsertStatement(len(rows)), args)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		if err := t.setAutoIncrementIDs(result, rowIDs); err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			if err := SetGeneratedIDs(&rows[i], rowIDs[i]); err != nil {
+				return nil, err
 			}
 		}
-		err := db.ExecContext(ctx, t.InsertStatement(len(s)), args)
-		if err == nil {
+	} else {
+		args, err := combineStructsWithPrefix[T](rows...)
+		if err != nil {
 			span.RecordError(err)
-			_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, t.FullTableName()+t.tmpPrefix)
+			return nil, err
+		}
+		_, err = db.ExecContext(ctx, t.InsertStatement(len(rows)), args)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
 		}
-		return generateIds[t.GetGenerateID()[0].Name], err
-	}
-
-	args, err := combineStructsWithPrefix[T](s...)
-	if err != nil {
-		span.RecordError(err)
-		return "", err
 	}
-	err = db.ExecContext(ctx, t.InsertStatement(len(s)), args)
-	if err == nil {
+	InvalidateCacheGroup(ctx, t.cacheGroup())
+	t.publishChanges(OperationInsert, rows...)
+	if err := runHooks(ctx, hookAfterInsert, rows); err != nil {
 		span.RecordError(err)
-		_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, t.FullTableName()+t.tmpPrefix)
+		return rowIDs, err
 	}
-	return "", err
+	return rowIDs, nil
 }
 
-type row[T any] struct {
-	index int
-	data  T
+func (t *Table[T]) CombineRows(ctx context.Context, rows ...T) (map[string]interface{}, error) {
+	args, _, err := t.rowArgs(rows...)
+	return args, err
 }
 
-func (t *Table[T]) CombineRows(ctx context.Context, rows ...T) (map[string]interface{}, error) {
-	c := make(chan map[string]interface{})
-	rowI := make(chan row[T])
-	Workers := 1
-
-	go func() {
-		for rowIndex, i := range rows {
-			rowI <- row[T]{
-				index: rowIndex,
-				data:  i,
-			}
-		}
-		close(rowI)
-	}()
-	generateIds := t.GenerateIDs(len(rows))
-	for i := 0; i < Workers; i++ {
-		go func() {
-			for r := range rowI {
-				tmpArgs, err := combineStructs(r.data)
-				if err != nil {
-					continue
-				}
-				tmpArgs = AddPrefix(fmt.Sprintf("%d_", r.index), tmpArgs)
-				c <- tmpArgs
-			}
-			close(c)
-		}()
-	}
-	var err error
-	args := map[string]interface{}{}
-	args, err = combineMaps(args, generateIds)
+func (t *Table[T]) Upsert(ctx context.Context, db DB, s ...T) (string, error) {
+	rowIDs, err := t.UpsertWithIDs(ctx, db, s...)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	for tmpArgs := range c {
-		args, err = combineMaps(args, tmpArgs)
-		if err != nil {
-			return nil, err
-		}
+	return t.firstGeneratedID(rowIDs), nil
+}
 
-	}
-	return args, nil
+// UpsertWithIDs upserts the rows and returns the auto generated ids of every row, in row order.
+// AUTO_INCREMENT columns are not reported since the last insert id is unreliable for updated rows.
+func (t *Table[T]) UpsertWithIDs(ctx context.Context, db DB, s ...T) ([]map[string]string, error) {
+	return t.upsertRows(ctx, db, append([]T(nil), s...))
 }
 
-func (t *Table[T]) Upsert(ctx context.Context, db DB, s ...T) (string, error) {
+func (t *Table[T]) upsertRows(ctx context.Context, db DB, rows []T) ([]map[string]string, error) {
 	if db == nil {
 		db = t.db
 	}
 	if db == nil {
-		return "", nil
+		return nil, nil
 	}
 	tracer := otel.GetTracerProvider()
 	ctx, span := tracer.Tracer("upsert").Start(ctx, t.FullTableName())
 	defer span.End()
-	if len(s) == 0 {
-		return "", nil
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	if err := t.setAuditRows(ctx, true, rows); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	if err := runHooks(ctx, hookBeforeInsert, rows); err != nil {
+		span.RecordError(err)
+		return nil, err
 	}
+	var rowIDs []map[string]string
 	if t.IsAutoGenerateID() {
-		args, err := t.CombineRows(ctx, s...)
+		var args map[string]interface{}
+		var err error
+		args, rowIDs, err = t.rowArgs(rows...)
 		if err != nil {
-			return "", err
+			return nil, err
+		}
+		// the history of the rows is keyed by the ids they are stored with
+		for i := range rows {
+			if err := SetGeneratedIDs(&rows[i], rowIDs[i]); err != nil {
+				return nil, err
+			}
 		}
-		err = db.ExecContext(ctx, t.UpsertStatement(len(s)), args)
-		if err == nil {
+		_, err = t.execWrite(ctx, db, OperationUpsert, rows, t.UpsertStatement(len(rows)), args)
+		if err != nil {
 			span.RecordError(err)
-			_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, t.FullTableName()+t.tmpPrefix)
+			return nil, err
+		}
+	} else {
+		args, err := combineStructsWithPrefix[T](rows...)
+		if err != nil {
+			return nil, err
+		}
+		_, err = t.execWrite(ctx, db, OperationUpsert, rows, t.UpsertStatement(len(rows)), args)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
 		}
-		return "", err
-	}
-	args, err := combineStructsWithPrefix[T](s...)
-	if err != nil {
-		return "", err
 	}
-	err = db.ExecContext(ctx, t.UpsertStatement(len(s)), args)
-	if err == nil {
+	InvalidateCacheGroup(ctx, t.cacheGroup())
+	t.writeThroughEntities(ctx, db, rows...)
+	t.publishChanges(OperationUpsert, rows...)
+	if err := runHooks(ctx, hookAfterInsert, rows); err != nil {
 		span.RecordError(err)
-		_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, t.FullTableName()+t.tmpPrefix)
+		return rowIDs, err
 	}
-	return "", err
+	return rowIDs, nil
 }
 
 func (t *Table[T]) UpsertGenerator(ctx context.Context, s ...T) (string, map[string]interface{}, error) {
@@ -883,24 +1026,67 @@ func (t *Table[T]) InsertTx(ctx context.Context, db *sqlx.Tx, s ...T) (sql.Resul
 	if db == nil {
 		return nil, "", nil
 	}
+	rows := append([]T(nil), s...)
+	if err := t.setAuditRows(ctx, true, rows); err != nil {
+		return nil, "", err
+	}
+	if err := runHooks(ctx, hookBeforeInsert, rows); err != nil {
+		return nil, "", err
+	}
+	var rowIDs []map[string]string
+	var results sql.Result
 	if t.IsAutoGenerateID() {
-		generateIds := t.GenerateID()
-		args, err := combineStructs(generateIds, s)
+		var args map[string]interface{}
+		var err error
+		args, rowIDs, err = t.rowArgs(rows...)
 		if err != nil {
 			return nil, "", err
 		}
-		results, err := db.NamedExecContext(ctx, t.InsertStatement(len(s)), args)
-		return results, generateIds[t.GetGenerateID()[0].Name], err
+		results, err = db.NamedExecContext(ctx, t.InsertStatement(len(rows)), args)
+		if err != nil {
+			return results, "", err
+		}
+		if err := t.setAutoIncrementIDs(results, rowIDs); err != nil {
+			return results, "", err
+		}
+		for i := range rows {
+			if err := SetGeneratedIDs(&rows[i], rowIDs[i]); err != nil {
+				return results, "", err
+			}
+		}
+	} else {
+		args, err := combineStructsWithPrefix[T](rows...)
+		if err != nil {
+			return nil, "", err
+		}
+		results, err = db.NamedExecContext(ctx, t.InsertStatement(len(rows)), args)
+		if err != nil {
+			return results, "", err
+		}
+	}
+	if err := t.appendOutbox(ctx, db, OperationInsert, rows...); err != nil {
+		return results, "", err
 	}
-	results, err := db.NamedExecContext(ctx, t.InsertStatement(len(s)), s)
-	return results, "", err
+	if err := runHooks(ctx, hookAfterInsert, rows); err != nil {
+		return results, t.firstGeneratedID(rowIDs), err
+	}
+	return results, t.firstGeneratedID(rowIDs), nil
 }
 func (t Table[T]) Prefix(groupPrefix string) *Table[T] {
 	t.tmpPrefix = groupPrefix
 	return &t
 }
 
+// cacheGroup is the cache group that writes to the table invalidate
+func (t *Table[T]) cacheGroup() string {
+	return t.FullTableName() + t.tmpPrefix
+}
+
 func (t *Table[T]) Delete(ctx context.Context, db DB, s T) error {
+	return t.deleteRow(ctx, db, &s)
+}
+
+func (t *Table[T]) deleteRow(ctx context.Context, db DB, s *T) error {
 	if db == nil {
 		db = t.db
 	}
@@ -910,28 +1096,51 @@ func (t *Table[T]) Delete(ctx context.Context, db DB, s T) error {
 	tracer := otel.GetTracerProvider()
 	ctx, span := tracer.Tracer("delete").Start(ctx, t.FullTableName())
 	defer span.End()
-	err := db.ExecContext(ctx, t.DeleteStatement(), s)
+	if err := runHook(ctx, hookBeforeDelete, s); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	result, err := t.execWrite(ctx, db, OperationDelete, []T{*s}, t.DeleteStatement(), *s)
 	if err != nil {
 		span.RecordError(err)
 		return err
 	}
-	_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, t.FullTableName()+t.tmpPrefix)
+	InvalidateCacheGroup(ctx, t.cacheGroup())
+	if err := t.checkRowsAffected(result); err != nil {
+		return err
+	}
+	t.evictEntities(ctx, *s)
+	t.publishChanges(OperationDelete, *s)
+	if err := runHook(ctx, hookAfterDelete, s); err != nil {
+		return err
+	}
 	return nil
 }
 
+// DeleteTx deletes the row with the callers transaction, call EvictEntities after committing when the entity cache is enabled
 func (t *Table[T]) DeleteTx(ctx context.Context, db *sqlx.Tx, s T) (sql.Result, error) {
 	if db == nil {
 		return nil, nil
 	}
-	r, err := db.NamedExecContext(ctx, t.DeleteStatement(), s)
+	if err := runHook(ctx, hookBeforeDelete, &s); err != nil {
+		return nil, err
+	}
+	r, err := t.execWriteTx(ctx, db, OperationDelete, []T{s}, t.DeleteStatement(), s)
 	if err != nil {
 		return r, err
 	}
-	_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, t.FullTableName()+t.tmpPrefix)
-	return r, nil
+	if err := t.appendOutbox(ctx, db, OperationDelete, s); err != nil {
+		return r, err
+	}
+	InvalidateCacheGroup(ctx, t.cacheGroup())
+	return r, runHook(ctx, hookAfterDelete, &s)
 }
 
 func (t *Table[T]) Update(ctx context.Context, db DB, s T) error {
+	return t.updateRow(ctx, db, &s)
+}
+
+func (t *Table[T]) updateRow(ctx context.Context, db DB, s *T) error {
 	if db == nil {
 		db = t.db
 	}
@@ -941,29 +1150,59 @@ func (t *Table[T]) Update(ctx context.Context, db DB, s T) error {
 	tracer := otel.GetTracerProvider()
 	ctx, span := tracer.Tracer("update").Start(ctx, t.FullTableName())
 	defer span.End()
-	err := db.ExecContext(ctx, t.UpdateStatement(), s)
+	if err := t.setAuditColumns(ctx, false, s); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if err := runHook(ctx, hookBeforeUpdate, s); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	result, err := t.execWrite(ctx, db, OperationUpdate, []T{*s}, t.UpdateStatement(), *s)
 	if err != nil {
 		span.RecordError(err)
 		return err
 	}
-	_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, t.FullTableName()+t.tmpPrefix)
+	InvalidateCacheGroup(ctx, t.cacheGroup())
+	if err := t.checkRowsAffected(result); err != nil {
+		return err
+	}
+	t.writeThroughEntities(ctx, db, *s)
+	t.publishChanges(OperationUpdate, *s)
+	if err := runHook(ctx, hookAfterUpdate, s); err != nil {
+		return err
+	}
 	return nil
 }
 
+// UpdateTx updates the row with the callers transaction, call EvictEntities after committing when the entity cache is enabled
 func (t *Table[T]) UpdateTx(ctx context.Context, db *sqlx.Tx, s T) (sql.Result, error) {
 	if db == nil {
 		return nil, nil
 	}
-	//tableUpdateSignal <- t.FullTableName()
-	r, err := db.ExecContext(ctx, t.UpdateStatement(), s)
+	if err := t.setAuditColumns(ctx, false, &s); err != nil {
+		return nil, err
+	}
+	if err := runHook(ctx, hookBeforeUpdate, &s); err != nil {
+		return nil, err
+	}
+	r, err := t.execWriteTx(ctx, db, OperationUpdate, []T{s}, t.UpdateStatement(), s)
 	if err != nil {
 		return nil, err
 	}
-	_ = ctx_cache.GlobalCacheMonitor.DeleteCache(ctx, t.FullTableName()+t.tmpPrefix)
-	return r, nil
+	if err := t.appendOutbox(ctx, db, OperationUpdate, s); err != nil {
+		return r, err
+	}
+	InvalidateCacheGroup(ctx, t.cacheGroup())
+	return r, runHook(ctx, hookAfterUpdate, &s)
 }
 
 func NamedQuery(ctx context.Context, db DB, query string, dbOptions *DBOptions, args ...interface{}) (DBRow, error) {
+	return preparedNamedQuery(ctx, db, "", query, dbOptions, args...)
+}
+
+// preparedNamedQuery runs the query with the prepared statement kept for name when db supports it
+func preparedNamedQuery(ctx context.Context, db DB, name, query string, dbOptions *DBOptions, args ...interface{}) (DBRow, error) {
 	if db == nil {
 		return nil, nil
 	}
@@ -971,8 +1210,16 @@ func NamedQuery(ctx context.Context, db DB, query string, dbOptions *DBOptions,
 	if err != nil {
 		return nil, err
 	}
-	query = fixArrays(query, a)
-	return db.QueryContext(ctx, query, dbOptions, a)
+	return queryArgs(ctx, db, name, query, dbOptions, a)
+}
+
+// queryArgs runs the query with the prepared statement kept for name when db supports it
+func queryArgs(ctx context.Context, db DB, name, query string, dbOptions *DBOptions, args map[string]interface{}) (DBRow, error) {
+	query = fixArrays(query, args)
+	if prepared, ok := db.(PreparedDB); ok && name != "" {
+		return prepared.PreparedQueryContext(ctx, name, query, dbOptions, args)
+	}
+	return db.QueryContext(ctx, query, dbOptions, args)
 }
 
 // ExtractColumns Extracts columns used in WHERE, JOIN, GROUP BY, and ORDER BY clauses
@@ -330,3 +330,122 @@ func GenerateAnswers(count int) []Answer {
 
 	return answers
 }
+
+// resultDB skips query validation, records the executed queries and returns a fixed exec result
+type resultDB struct {
+	MockDB
+	result  sql.Result
+	queries []string
+	args    []interface{}
+}
+
+func (r *resultDB) ExecContext(ctx context.Context, query string, args interface{}) (sql.Result, error) {
+	r.queries = append(r.queries, query)
+	r.args = append(r.args, args)
+	return r.result, nil
+}
+
+type Counter struct {
+	ID    int64  `json:"id" db:"id" qc:"primary;auto_generate_id"`
+	Name  string `json:"name" db:"name" qc:"update"`
+	Count int    `json:"count" db:"count" qc:"update"`
+}
+
+func TestTable_ErrOnNoRows(t *testing.T) {
+	counterTable, err := NewTable[Counter]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := counterTable.Update(ctx, NewMockDB(), Counter{ID: 1}); err != nil {
+		t.Fatalf("expected no error without ErrOnNoRows, got %v", err)
+	}
+	if err := counterTable.ErrOnNoRows().Update(ctx, NewMockDB(), Counter{ID: 1}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+	if err := counterTable.ErrOnNoRows().Delete(ctx, NewMockDB(), Counter{ID: 1}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestTable_InsertAutoIncrement(t *testing.T) {
+	counterTable, err := NewTable[Counter]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := counterTable.GenerateID(); len(ids) != 0 {
+		t.Fatalf("expected no generated ids for AUTO_INCREMENT column, got %v", ids)
+	}
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{lastInsertID: 42, rowsAffected: 1}}
+	id, err := counterTable.Insert(context.Background(), db, Counter{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "42" {
+		t.Fatalf("expected last insert id from mock result, got %q", id)
+	}
+}
+
+type HexRow struct {
+	ID   string `json:"id" db:"id" qc:"primary;auto_generate_id;auto_generate_id_type::hex"`
+	Name string `json:"name" db:"name" qc:"update"`
+}
+
+func TestTable_InsertWithIDs(t *testing.T) {
+	hexTable, err := NewTable[HexRow]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{rowsAffected: 3}}
+	rowIDs, err := hexTable.InsertWithIDs(context.Background(), db, HexRow{}, HexRow{}, HexRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rowIDs) != 3 {
+		t.Fatalf("expected ids for 3 rows, got %d", len(rowIDs))
+	}
+	hexID := regexp.MustCompile(`^[0-9a-f]{40}$`)
+	dup := map[string]struct{}{}
+	for _, ids := range rowIDs {
+		if !hexID.MatchString(ids["id"]) {
+			t.Errorf("expected sha1 hex id, got %q", ids["id"])
+		}
+		if _, found := dup[ids["id"]]; found {
+			t.Errorf("found duplicate id %s", ids["id"])
+		}
+		dup[ids["id"]] = struct{}{}
+	}
+	for k, v := range hexTable.GenerateIDs(2) {
+		if !hexID.MatchString(safeString(v)) {
+			t.Errorf("expected sha1 hex id for %s, got %v", k, v)
+		}
+	}
+}
+
+func TestTable_InsertWithIDsAutoIncrement(t *testing.T) {
+	counterTable, err := NewTable[Counter]("test", QueryTypeSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &resultDB{MockDB: *NewMockDB(), result: mockResult{lastInsertID: 10, rowsAffected: 2}}
+	rowIDs, err := counterTable.InsertWithIDs(context.Background(), db, Counter{}, Counter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := rowIDs[1]["id"]; rowIDs[0]["id"] != "10" || found {
+		t.Fatalf("expected only the id of the first row, got %v", rowIDs)
+	}
+	c := &Counter{ID: 7}
+	if err := SetGeneratedIDs(c, rowIDs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != 7 {
+		t.Fatalf("expected unreported ids to be left unchanged, got %d", c.ID)
+	}
+	if err := SetGeneratedIDs(c, rowIDs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != 10 {
+		t.Fatalf("expected id to be written back, got %d", c.ID)
+	}
+}
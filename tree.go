@@ -0,0 +1,98 @@
+package QueryHelper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tree loads the descendants and ancestors of the rows of a table that reference their parent row through a column
+type Tree[T any] struct {
+	table    *Table[T]
+	id       Column
+	parent   Column
+	maxDepth int
+}
+
+func NewTree[T any](table *Table[T], idColumn, parentColumn string) (*Tree[T], error) {
+	id := table.GetColumn(idColumn)
+	if id.Name == "" {
+		return nil, fmt.Errorf("missing column %s from table(%s)", idColumn, table.FullTableName())
+	}
+	parent := table.GetColumn(parentColumn)
+	if parent.Name == "" {
+		return nil, fmt.Errorf("missing column %s from table(%s)", parentColumn, table.FullTableName())
+	}
+	return &Tree[T]{table: table, id: id, parent: parent}, nil
+}
+
+// MaxDepth stops the recursion after depth levels below or above the node, it guards against cycles in the data
+func (t *Tree[T]) MaxDepth(depth int) *Tree[T] {
+	tree := *t
+	tree.maxDepth = depth
+	return &tree
+}
+
+// Descendants returns a query for the node with the id and every row below it
+func (t *Tree[T]) Descendants(id interface{}) *Query[T] {
+	return t.query(id, t.parent, t.id)
+}
+
+// Ancestors returns a query for the node with the id and every row above it
+func (t *Tree[T]) Ancestors(id interface{}) *Query[T] {
+	return t.query(id, t.id, t.parent)
+}
+
+func (t *Tree[T]) name() string {
+	return t.table.Name + "_tree"
+}
+
+// query selects the rows of the recursive expression, a row is part of the tree when its joined column matches the
+// referenced column of a row already in the tree
+func (t *Tree[T]) query(id interface{}, joined, referenced Column) *Query[T] {
+	return QueryTable[T](t.table).WithRecursive(t.name(), t.recursive(id, joined, referenced)).FromWith(t.name())
+}
+
+func (t *Tree[T]) recursive(id interface{}, joined, referenced Column) Subquery {
+	names := make([]string, 0, len(t.table.Columns))
+	for _, c := range t.table.Columns {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	columns := make([]string, len(names))
+	for i, name := range names {
+		columns[i] = fmt.Sprintf("%s.%s", t.table.Name, name)
+	}
+
+	args := map[string]interface{}{"id": id}
+	query := fmt.Sprintf(
+		"SELECT\n\t%s,\n\t0 AS tree_depth\nFROM\n\t%s\nWHERE %s.%s = :id\nUNION ALL\nSELECT\n\t%s,\n\t%s.tree_depth + 1\nFROM\n\t%s\nJOIN %s ON %s.%s = %s.%s",
+		strings.Join(columns, ",\n\t"), t.table.FullTableName(), t.table.Name, t.id.Name,
+		strings.Join(columns, ",\n\t"), t.name(), t.table.FullTableName(),
+		t.name(), t.table.Name, joined.Name, t.name(), referenced.Name,
+	)
+	if t.maxDepth > 0 {
+		query = fmt.Sprintf("%s\nWHERE %s.tree_depth < :max_depth", query, t.name())
+		args["max_depth"] = t.maxDepth
+	}
+	return &treeQuery{
+		query: query,
+		args:  args,
+		tags:  []string{t.table.FullTableName() + t.table.tmpPrefix},
+	}
+}
+
+// treeQuery is the recursive expression of a tree, it is rendered by the tree instead of the query builder
+type treeQuery struct {
+	query string
+	args  map[string]interface{}
+	tags  []string
+}
+
+func (t *treeQuery) subquery() (string, map[string]interface{}, error) {
+	return t.query, t.args, nil
+}
+
+func (t *treeQuery) subqueryTags() []string {
+	return t.tags
+}
@@ -0,0 +1,227 @@
+package QueryHelper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidCondition = errors.New("invalid where condition")
+
+// Expr is a boolean expression of a where clause built with Cond, And, Or and Not. Nested groups are always
+// parenthesized and every value is bound to its own parameter, so the expression renders the way it is built.
+type Expr interface {
+	render(params *exprParams) (string, error)
+	conditions() []*WhereStmt
+}
+
+// Cond compares the column with the value, it can be combined with And, Or and Not
+func Cond(column Column, conditional string, value interface{}) *WhereStmt {
+	return &WhereStmt{
+		LeftValue:    column,
+		Conditional:  conditional,
+		RightValue:   value,
+		JoinOperator: "AND",
+	}
+}
+
+type exprGroup struct {
+	operator string
+	exprs    []Expr
+}
+
+// And is true when every expression is true, nil expressions are ignored
+func And(exprs ...Expr) Expr {
+	return &exprGroup{operator: "AND", exprs: exprs}
+}
+
+// Or is true when any expression is true, nil expressions are ignored
+func Or(exprs ...Expr) Expr {
+	return &exprGroup{operator: "OR", exprs: exprs}
+}
+
+type notExpr struct {
+	expr Expr
+}
+
+func Not(expr Expr) Expr {
+	return &notExpr{expr: expr}
+}
+
+func (g *exprGroup) render(params *exprParams) (string, error) {
+	var parts []string
+	for _, e := range g.exprs {
+		if isNilExpr(e) {
+			continue
+		}
+		part, err := e.render(params)
+		if err != nil {
+			return "", err
+		}
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	switch len(parts) {
+	case 0:
+		return "", nil
+	case 1:
+		return parts[0], nil
+	}
+	return "(" + strings.Join(parts, " "+g.operator+" ") + ")", nil
+}
+
+func (g *exprGroup) conditions() []*WhereStmt {
+	var output []*WhereStmt
+	for _, e := range g.exprs {
+		if !isNilExpr(e) {
+			output = append(output, e.conditions()...)
+		}
+	}
+	return output
+}
+
+func (n *notExpr) render(params *exprParams) (string, error) {
+	if isNilExpr(n.expr) {
+		return "", nil
+	}
+	part, err := n.expr.render(params)
+	if err != nil || part == "" {
+		return "", err
+	}
+	if !strings.HasPrefix(part, "(") {
+		part = "(" + part + ")"
+	}
+	return "NOT " + part, nil
+}
+
+func (n *notExpr) conditions() []*WhereStmt {
+	if isNilExpr(n.expr) {
+		return nil
+	}
+	return n.expr.conditions()
+}
+
+func (w *WhereStmt) render(params *exprParams) (string, error) {
+	formatted, args, err := w.bind(params.reserve(w.paramName()))
+	if err != nil {
+		return "", err
+	}
+	params.add(args)
+	return formatted, nil
+}
+
+func (w *WhereStmt) conditions() []*WhereStmt {
+	return []*WhereStmt{w}
+}
+
+func isNilExpr(e Expr) bool {
+	if e == nil {
+		return true
+	}
+	if w, ok := e.(*WhereStmt); ok && w == nil {
+		return true
+	}
+	return false
+}
+
+// exprParams names the parameters of the expressions, names already used by the level based statements are skipped
+type exprParams struct {
+	used map[string]bool
+	args map[string]interface{}
+}
+
+func newExprParams(whereStatements []*WhereStmt) *exprParams {
+	params := &exprParams{
+		used: map[string]bool{},
+		args: map[string]interface{}{},
+	}
+	for _, w := range whereStatements {
+		params.used[w.paramName()] = true
+		if _, args, err := w.bind(w.paramName()); err == nil {
+			for k := range args {
+				params.used[k] = true
+			}
+		}
+	}
+	return params
+}
+
+// reserve returns name, or name with the lowest free numeric suffix when name is taken
+func (p *exprParams) reserve(name string) string {
+	param := name
+	for i := 1; p.used[param]; i++ {
+		param = fmt.Sprintf("%s_%d", name, i)
+	}
+	p.used[param] = true
+	return param
+}
+
+func (p *exprParams) add(args map[string]interface{}) {
+	for k, v := range args {
+		p.used[k] = true
+		p.args[k] = v
+	}
+}
+
+// WhereExpr adds the expression to the where clause, it is joined with AND to the other statements
+func (q *Query[T]) WhereExpr(expr Expr) *Query[T] {
+	q = q.edit()
+	if isNilExpr(expr) {
+		return q
+	}
+	if _, err := expr.render(newExprParams(nil)); err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return q
+	}
+	if !exprSavable(expr) {
+		q.cansave = false
+	}
+	q.WhereExprs = append(q.WhereExprs, expr)
+	return q
+}
+
+// exprSavable reports whether the sql of the expression is the same for every value, in conditions expand their
+// values and subqueries are built with their own values
+func exprSavable(expr Expr) bool {
+	if isNilExpr(expr) {
+		return true
+	}
+	for _, w := range expr.conditions() {
+		if _, isSubquery := w.RightValue.(Subquery); isSubquery || strings.Contains(w.Conditional, "in") {
+			return false
+		}
+	}
+	return true
+}
+
+// renderWhereExprs returns the rendered where expressions and the values of their parameters
+func (q *Query[T]) renderWhereExprs() ([]string, map[string]interface{}) {
+	params := newExprParams(q.WhereStmts)
+	var parts []string
+	for _, e := range q.WhereExprs {
+		// expressions are checked when they are added
+		if part, err := e.render(params); err == nil && part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts, params.args
+}
+
+// whereClause renders the level based statements and the where expressions joined with AND
+func (q *Query[T]) whereClause() string {
+	conditions := strings.TrimSpace(whereConditions(q.WhereStmts))
+	exprs, _ := q.renderWhereExprs()
+	if len(exprs) == 0 {
+		if conditions == "" {
+			return ""
+		}
+		return generateWhere(q.WhereStmts)
+	}
+	if conditions != "" {
+		exprs = append([]string{"(" + conditions + ")"}, exprs...)
+	}
+	return "WHERE " + strings.Join(exprs, " AND ")
+}
@@ -0,0 +1,185 @@
+package QueryHelper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TopNRankColumn is the name of the rank TopNPerGroup numbers the rows of every group with, see Query.DerivedColumn
+const TopNRankColumn = "group_rank"
+
+// Window is the over clause of a window function, order by columns are sorted by their OrderAsc direction
+type Window struct {
+	partitionBy []Column
+	orderBy     []Column
+}
+
+func PartitionBy(columns ...Column) Window {
+	return Window{}.PartitionBy(columns...)
+}
+
+func (w Window) PartitionBy(columns ...Column) Window {
+	w.partitionBy = append(w.partitionBy[:len(w.partitionBy):len(w.partitionBy)], columns...)
+	return w
+}
+
+func (w Window) OrderBy(columns ...Column) Window {
+	w.orderBy = append(w.orderBy[:len(w.orderBy):len(w.orderBy)], columns...)
+	return w
+}
+
+func (w Window) render() string {
+	var parts []string
+	if len(w.partitionBy) > 0 {
+		columns := make([]string, len(w.partitionBy))
+		for i, c := range w.partitionBy {
+			columns[i] = c.expression(false, true)
+		}
+		parts = append(parts, "PARTITION BY "+strings.Join(columns, ", "))
+	}
+	if len(w.orderBy) > 0 {
+		columns := make([]string, len(w.orderBy))
+		for i, c := range w.orderBy {
+			direction := "DESC"
+			if c.OrderAsc {
+				direction = "ASC"
+			}
+			columns[i] = c.expression(false, true) + " " + direction
+		}
+		parts = append(parts, "ORDER BY "+strings.Join(columns, ", "))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (w Window) validate() error {
+	for _, c := range append(w.partitionBy[:len(w.partitionBy):len(w.partitionBy)], w.orderBy...) {
+		if c.Name == "" {
+			return fmt.Errorf("%w: window over a missing column", ErrInvalidCondition)
+		}
+	}
+	return nil
+}
+
+type windowFunction struct {
+	call string
+	over Window
+	err  error
+}
+
+func (f *windowFunction) render() string {
+	return fmt.Sprintf("%s OVER (%s)", f.call, f.over.render())
+}
+
+// windowColumn is a select column computed by the window function, it is selected as name unless it is renamed with As
+func windowColumn(name, call string, over Window, err error) Column {
+	if err == nil {
+		err = over.validate()
+	}
+	return Column{Name: name, Select: true, window: &windowFunction{call: call, over: over, err: err}}
+}
+
+// RowNumber numbers the rows of every partition from 1 in the order of the window, it is selected as row_num since
+// the function names are reserved words in mysql
+func RowNumber(over Window) Column {
+	return windowColumn("row_num", "ROW_NUMBER()", over, nil)
+}
+
+// Rank numbers the rows of every partition in the order of the window, equal rows share a rank and leave a gap. It
+// is selected as row_rank.
+func Rank(over Window) Column {
+	return windowColumn("row_rank", "RANK()", over, nil)
+}
+
+// DenseRank is a Rank without gaps, selected as row_dense_rank
+func DenseRank(over Window) Column {
+	return windowColumn("row_dense_rank", "DENSE_RANK()", over, nil)
+}
+
+// Lag is the value of the column offset rows before the row in the window, null when there is no such row
+func Lag(column Column, offset int, over Window) Column {
+	return offsetColumn("LAG", column, offset, over)
+}
+
+// Lead is the value of the column offset rows after the row in the window, null when there is no such row
+func Lead(column Column, offset int, over Window) Column {
+	return offsetColumn("LEAD", column, offset, over)
+}
+
+func offsetColumn(function string, column Column, offset int, over Window) Column {
+	var err error
+	if column.Name == "" || offset < 0 {
+		err = fmt.Errorf("%w: %s requires a column and a positive offset", ErrInvalidCondition, function)
+	}
+	return windowColumn(
+		strings.ToLower(function)+"_"+column.Name,
+		fmt.Sprintf("%s(%s, %d)", function, column.expression(false, true), offset),
+		over, err,
+	)
+}
+
+// AggregateOver applies an aggregate function such as SUM, AVG or COUNT to the rows of the window, with an order
+// by the aggregate is a running total
+func AggregateOver(function string, column Column, over Window) Column {
+	var err error
+	if !identifier.MatchString(function) || column.Name == "" {
+		err = fmt.Errorf("%w: invalid window aggregate %s(%s)", ErrInvalidCondition, function, column.Name)
+	}
+	function = strings.ToUpper(function)
+	return windowColumn(
+		strings.ToLower(function)+"_"+column.Name,
+		fmt.Sprintf("%s(%s)", function, column.expression(false, true)),
+		over, err,
+	)
+}
+
+// DerivedColumn references a column that is not part of the table, such as a column of a common table expression
+// or a derived table that the query selects from
+func (q *Query[T]) DerivedColumn(name string) Column {
+	return Column{Name: name, Table: q.FromTable.Name, Select: true, derived: true}
+}
+
+// TopNPerGroup returns the first n rows of every group of rows with equal partition columns, in the order of the
+// order by columns. The where clause and joins of q select the rows that are ranked, its select list, order and limit
+// apply to the ranked rows. The rank of every row can be selected with DerivedColumn(TopNRankColumn).
+func TopNPerGroup[T any](q *Query[T], n int, partitionBy []Column, orderBy ...Column) *Query[T] {
+	outer := QueryTable[T](q.FromTable)
+	if q.err != nil {
+		outer.err = q.err
+		return outer
+	}
+	if n <= 0 {
+		outer.err = fmt.Errorf("%w: top n per group requires a positive n", ErrInvalidCondition)
+		return outer
+	}
+
+	ranked := q.Clone()
+	ranked.immutable = false
+	ranked.SelectColumns = nil
+	ranked.OrderByStmt = nil
+	ranked.LimitCount = 0
+	ranked.Pagination.Limit = 0
+	ranked.Pagination.Offset = 0
+	names := make([]string, 0, len(q.FromTable.Columns))
+	for name, c := range q.FromTable.Columns {
+		if c.Select {
+			names = append(names, name)
+		}
+	}
+	// sorted so the ranked rows always render the same sql
+	sort.Strings(names)
+	for _, name := range names {
+		ranked.Select(q.FromTable.Columns[name])
+	}
+	ranked.Select(RowNumber(PartitionBy(partitionBy...).OrderBy(orderBy...)).As(TopNRankColumn))
+
+	name := q.FromTable.Name + "_ranked"
+	outer.With(name, ranked).FromWith(name).
+		Select(q.SelectColumns...).
+		WhereExpr(Cond(outer.DerivedColumn(TopNRankColumn), "<=", n)).
+		OrderBy(q.OrderByStmt...).
+		Limit(q.LimitCount)
+	outer.Pagination = q.Pagination
+	outer.immutable = q.immutable
+	return outer
+}